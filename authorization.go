@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/groupme"
+)
+
+var roleLevels = map[string]int{
+	database.ROLE_MEMBER:    0,
+	database.ROLE_CAPTAIN:   1,
+	database.ROLE_TREASURER: 2,
+	database.ROLE_ADMIN:     3,
+}
+
+var userIDRegexp = regexp.MustCompile(`^\d+$`)
+
+// authorize checks that the sender has at least the role required by the command
+func (mp *MessageProcessor) authorize(m GroupmeMessage, command *Command) (bool, error) {
+	if command.Role == "" || command.Role == database.ROLE_MEMBER {
+		return true, nil
+	}
+	role, err := mp.senderRole(m.SenderId)
+	if err != nil {
+		return false, err
+	}
+	return roleLevels[role] >= roleLevels[command.Role], nil
+}
+
+func (mp *MessageProcessor) senderRole(userID string) (string, error) {
+	for _, admin := range mp.adminIDs {
+		if admin == userID {
+			return database.ROLE_ADMIN, nil
+		}
+	}
+	return mp.db.GetRole(userID)
+}
+
+// targetUser resolves the user a command is about - either the first
+// @mention of the message or a plain GroupMe user ID
+func targetUser(m GroupmeMessage, user string) (string, error) {
+	if mentioned := m.MentionedUserIDs(); len(mentioned) > 0 {
+		return mentioned[0], nil
+	}
+	user = strings.TrimSpace(user)
+	if !userIDRegexp.MatchString(user) {
+		return "", fmt.Errorf("unknown user: %s, use @mention or GroupMe user ID", user)
+	}
+	return user, nil
+}
+
+func (mp *MessageProcessor) grantRole(ms *groupme.MessageService, m GroupmeMessage, role, user string) error {
+	role = strings.ToLower(role)
+	if _, ok := roleLevels[role]; !ok {
+		log.Printf("Unknown role: %s\n", role)
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	userID, err := targetUser(m, user)
+	if err != nil {
+		return err
+	}
+	if role == database.ROLE_MEMBER {
+		err = mp.db.RemoveRole(userID)
+	} else {
+		err = mp.db.SetRole(userID, role)
+	}
+	if err != nil {
+		log.Printf("Unable to store role: %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Role %s granted to %s", role, userID), "")
+}
+
+func (mp *MessageProcessor) revokeRole(ms *groupme.MessageService, m GroupmeMessage, user string) error {
+	userID, err := targetUser(m, user)
+	if err != nil {
+		return err
+	}
+	if userID == m.SenderId {
+		return errors.New("you can't revoke your own role")
+	}
+	if err := mp.db.RemoveRole(userID); err != nil {
+		log.Printf("Unable to remove role: %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Role revoked from %s", userID), "")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/google"
+	"github.com/vlcak/groupme_qr_bot/groupme"
+)
+
+const STATEMENT_DATE_FORMAT = "2.1."
+
+// senderPlayer returns name of the player linked to the sender
+func (mp *MessageProcessor) senderPlayer(senderId string) (string, error) {
+	player, err := mp.db.GetPlayerByGroupmeUser(senderId)
+	if err != nil {
+		log.Printf("Unable to get player of %s: %v\n", senderId, err)
+		return "", err
+	}
+	if !player.Id.Valid {
+		return "", errors.New("you are not linked to any player, use LINK_PLAYER <name>")
+	}
+	return player.Name.String, nil
+}
+
+// balancePlayer returns the player whose balance is requested, other players
+// can be checked only by treasurer
+func (mp *MessageProcessor) balancePlayer(senderId, name string) (string, error) {
+	if name == "" {
+		return mp.senderPlayer(senderId)
+	}
+	role, err := mp.senderRole(senderId)
+	if err != nil {
+		log.Printf("Can't check role of %s: %v\n", senderId, err)
+		return "", err
+	}
+	if roleLevels[role] < roleLevels[database.ROLE_TREASURER] {
+		return "", fmt.Errorf("balance of other players requires %s role", database.ROLE_TREASURER)
+	}
+	player, err := mp.db.GetPlayerByName(name)
+	if err != nil || !player.Id.Valid {
+		log.Printf("Unable to get player: %s, err:%v\n", name, err)
+		return "", fmt.Errorf("unknown player: %s", name)
+	}
+	return player.Name.String, nil
+}
+
+func (mp *MessageProcessor) sendBalance(ms *groupme.MessageService, senderId, name string) error {
+	name, err := mp.balancePlayer(senderId, name)
+	if err != nil {
+		return err
+	}
+	balances, err := mp.ledger.Balances()
+	if err != nil {
+		log.Printf("Can't get balances %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Balance of %s: %d", name, balances[name]), "")
+}
+
+// sendBalances lists balances of the whole team, biggest debts first
+func (mp *MessageProcessor) sendBalances(ms *groupme.MessageService) error {
+	balances, err := mp.ledger.Balances()
+	if err != nil {
+		log.Printf("Can't get balances %v\n", err)
+		return err
+	}
+	names := make([]string, 0, len(balances))
+	for name := range balances {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if balances[names[i]] != balances[names[j]] {
+			return balances[names[i]] < balances[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	message := "Balances:\n"
+	debt := 0
+	for _, name := range names {
+		message += fmt.Sprintf("%s: %d\n", name, balances[name])
+		if balances[name] < 0 && name != google.HOSTS {
+			debt -= balances[name]
+		}
+	}
+	message += fmt.Sprintf("Total debt: %d", debt)
+	return ms.SendMessage(message, "")
+}
+
+// sendStatement lists payments and charges of the sender in the month
+func (mp *MessageProcessor) sendStatement(ms *groupme.MessageService, senderId, month string) error {
+	name, err := mp.senderPlayer(senderId)
+	if err != nil {
+		return err
+	}
+	from, err := parseMonth(month, time.Now())
+	if err != nil {
+		return err
+	}
+	to := from.AddDate(0, 1, 0)
+	entries, err := mp.ledger.Entries(name, from, to)
+	if err != nil {
+		log.Printf("Can't get ledger entries %v\n", err)
+		return err
+	}
+
+	message := fmt.Sprintf("Statement of %s for %s:\n", name, from.Format("1/2006"))
+	total := 0
+	for _, entry := range entries {
+		message += fmt.Sprintf("%s %+d %s\n", entry.CreatedAt.Time.Format(STATEMENT_DATE_FORMAT), entry.Amount.Int64, strings.TrimSpace(entry.Description.String))
+		total += int(entry.Amount.Int64)
+	}
+	if len(entries) == 0 {
+		message += "no payments or charges\n"
+	}
+	balances, err := mp.ledger.Balances()
+	if err != nil {
+		log.Printf("Can't get balances %v\n", err)
+		return err
+	}
+	message += fmt.Sprintf("Month total: %+d, balance: %d", total, balances[name])
+	return ms.SendMessage(message, "")
+}
+
+func (mp *MessageProcessor) setReminders(ms *groupme.MessageService, senderId, state string) error {
+	var optOut bool
+	switch strings.ToLower(state) {
+	case "on":
+		optOut = false
+	case "off":
+		optOut = true
+	default:
+		return fmt.Errorf("invalid state: %s, use on or off", state)
+	}
+	name, err := mp.senderPlayer(senderId)
+	if err != nil {
+		return err
+	}
+	if err := mp.db.SetReminderOptOut(name, optOut); err != nil {
+		log.Printf("Unable to store reminder opt-out: %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Debt reminders of %s turned %s", name, strings.ToLower(state)), "")
+}
+
+// parseMonth parses month as M, M/YYYY or YYYY-MM, current month when empty
+func parseMonth(month string, now time.Time) (time.Time, error) {
+	year := now.Year()
+	var m int
+	var err error
+	switch {
+	case month == "":
+		m = int(now.Month())
+	case strings.Contains(month, "-"):
+		var t time.Time
+		t, err = time.Parse("2006-01", month)
+		year, m = t.Year(), int(t.Month())
+	case strings.Contains(month, "/"):
+		var t time.Time
+		t, err = time.Parse("1/2006", month)
+		year, m = t.Year(), int(t.Month())
+	default:
+		m, err = strconv.Atoi(month)
+		// months in the future belong to the last year
+		if err == nil && m > int(now.Month()) {
+			year--
+		}
+	}
+	if err != nil || m < 1 || m > 12 {
+		return time.Time{}, fmt.Errorf("invalid month: %s, use M, M/YYYY or YYYY-MM", month)
+	}
+	return time.Date(year, time.Month(m), 1, 0, 0, 0, 0, now.Location()), nil
+}
@@ -4,15 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 
 	database "github.com/vlcak/groupme_qr_bot/db"
@@ -20,14 +19,20 @@ import (
 
 const (
 	TEMP_TRANSACTIONS_FILE = "transactions.json"
+	CSOB_SCRAPER_TIMEOUT   = 60 * time.Second
+	CSOB_BANK_CODE         = "0300"
 )
 
+// NewCsobClient creates client of the ČSOB transparent account, payments are
+// read from the transaction list API with the browser scraper as a fallback
 func NewCsobClient(accountNumber int, db *database.Client) *CsobClient {
+	url := fmt.Sprintf("https://csob.cz/firmy/bezne-ucty/transparentni-ucty/ucet?account=%d", accountNumber)
 	return &CsobClient{
 		accountNumber: accountNumber,
-		url:           fmt.Sprintf("https://csob.cz/firmy/bezne-ucty/transparentni-ucty/ucet?account=%d", accountNumber),
+		url:           url,
 		db:            db,
 		viewURL:       fmt.Sprintf("https://www.csob.cz/portal/firmy/bezne-ucty/transparentni-ucty/ucet?account=%d", accountNumber),
+		source:        NewFallbackSource(NewCsobTransparentAccount(accountNumber), NewCsobScraper(accountNumber, url)),
 	}
 }
 
@@ -36,62 +41,27 @@ type CsobClient struct {
 	url           string
 	db            *database.Client
 	viewURL       string
-}
-
-type Payment struct {
-	Name          string
-	AccountNumber string
-	Message       string
-	Amount        int
-	Order         int
-	Timestamp     time.Time
+	source        PaymentSource
 }
 
 func (cc *CsobClient) CheckPayments() ([]Payment, error) {
-	previousLastAccountingOrder, err := cc.db.GetLastPaymentOrder()
-	if err != nil {
-		log.Printf("Can't get last accounting order: %v", err)
-		return nil, err
-	}
-	log.Printf("Getting payments since: %d", previousLastAccountingOrder)
-	payments, err := cc.paymentsSinceLastCheck(previousLastAccountingOrder)
-	if err != nil {
-		log.Printf("Can't get payments: %v", err)
-		return nil, err
-	}
-	// Store payments to DB
-	for _, payment := range payments {
-		err = cc.db.StorePayment(payment.Name, payment.AccountNumber, payment.Amount, payment.Order, payment.Timestamp)
-		if err != nil {
-			log.Printf("Can't store payment: %v", err)
-			return nil, err
-		}
-	}
-
-	return payments, nil
+	return CheckPayments(cc, cc.db)
 }
 
+// CheckPaymentsFromFile imports statement file in any supported format,
+// already stored payments are skipped so the file is kept
 func (cc *CsobClient) CheckPaymentsFromFile() ([]Payment, error) {
-	previousLastAccountingOrder, err := cc.db.GetLastPaymentOrder()
+	data, err := os.ReadFile(TEMP_TRANSACTIONS_FILE)
 	if err != nil {
-		log.Printf("Can't get last accounting order: %v", err)
+		log.Printf("Can't read file: %s, err: %v", TEMP_TRANSACTIONS_FILE, err)
 		return nil, err
 	}
-	log.Printf("Getting payments since: %d", previousLastAccountingOrder)
-	payments, err := cc.paymentsSinceLastCheckFromFile(previousLastAccountingOrder, TEMP_TRANSACTIONS_FILE)
+	payments, skipped, err := ImportStatement(data, cc.db)
 	if err != nil {
-		log.Printf("Can't get payments: %v", err)
+		log.Printf("Can't import payments: %v", err)
 		return nil, err
 	}
-	// Store payments to DB
-	for _, payment := range payments {
-		err = cc.db.StorePayment(payment.Name, payment.AccountNumber, payment.Amount, payment.Order, payment.Timestamp)
-		if err != nil {
-			log.Printf("Can't store payment: %v", err)
-			return nil, err
-		}
-	}
-
+	log.Printf("Imported payments: %d, skipped: %d", len(payments), skipped)
 	return payments, nil
 }
 
@@ -100,7 +70,7 @@ func (cc *CsobClient) GetAccountURL() string {
 }
 
 func (cc *CsobClient) PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
-	payments, err := cc.paymentsSinceLastCheck(lastAccountingOrder)
+	payments, err := cc.source.PaymentsSinceLastCheck(lastAccountingOrder)
 	if err != nil {
 		log.Printf("Can't get payments: %v", err)
 		return nil, err
@@ -138,11 +108,35 @@ type transaction struct {
 				}
 			}
 			PartyName string
+			Symbols   struct {
+				VariableSymbol string
+			}
 		}
 	}
 }
 
-func (cc *CsobClient) paymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
+// NewCsobScraper creates source reading the transactions from the account web
+// page in headless Chrome
+func NewCsobScraper(accountNumber int, url string) *CsobScraper {
+	return &CsobScraper{
+		accountNumber: accountNumber,
+		url:           url,
+	}
+}
+
+type CsobScraper struct {
+	accountNumber int
+	url           string
+}
+
+// transactionPage is a transaction list page requested from the browser
+type transactionPage struct {
+	page     int
+	response bankResponse
+	err      error
+}
+
+func (cs *CsobScraper) PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
 	dir, err := os.MkdirTemp("", "csob")
 	if err != nil {
 		return nil, err
@@ -165,7 +159,7 @@ func (cc *CsobClient) paymentsSinceLastCheck(lastAccountingOrder int) ([]Payment
 	defer cancel()
 
 	// create a timeout
-	taskCtx, cancel = context.WithTimeout(taskCtx, 10*time.Second)
+	taskCtx, cancel = context.WithTimeout(taskCtx, CSOB_SCRAPER_TIMEOUT)
 	defer cancel()
 
 	// ensure that the browser process is started
@@ -173,57 +167,133 @@ func (cc *CsobClient) paymentsSinceLastCheck(lastAccountingOrder int) ([]Payment
 		return nil, err
 	}
 
-	// listen network event
-	pReference := listenForNetworkEvent(taskCtx, lastAccountingOrder)
+	urls := make(chan string, 1)
+	listenForNetworkEvent(taskCtx, urls)
 
-	chromedp.Run(taskCtx,
+	if err := chromedp.Run(taskCtx,
 		network.Enable(),
-		chromedp.Navigate(cc.url),
-		chromedp.Sleep(10*time.Second),
-		chromedp.WaitVisible(`body`, chromedp.BySearch),
-	)
+		chromedp.Navigate(cs.url),
+	); err != nil {
+		return nil, err
+	}
+
+	// only the URL of the transaction list is taken from the page, all pages
+	// are requested from it so they are sent with its cookies
+	var url string
+	select {
+	case <-taskCtx.Done():
+		return nil, fmt.Errorf("transaction list not requested by the page: %w", taskCtx.Err())
+	case url = <-urls:
+	}
+
+	pages := make(chan transactionPage)
+	return collectPages(taskCtx, pages, lastAccountingOrder, func(page int) error {
+		script, err := cs.fetchPageScript(url, page)
+		if err != nil {
+			return err
+		}
+		go func() {
+			result := transactionPage{page: page}
+			var body string
+			result.err = chromedp.Run(taskCtx, chromedp.Evaluate(script, &body, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+				return p.WithAwaitPromise(true)
+			}))
+			if result.err == nil {
+				if result.err = json.Unmarshal([]byte(body), &result.response); result.err != nil {
+					log.Printf("Can't unmarshal bank response body err: %v\nbody: %v, url: %s", result.err, body, url)
+				}
+			}
+			select {
+			case pages <- result:
+			case <-taskCtx.Done():
+			}
+		}()
+		return nil
+	})
+}
+
+func (cs *CsobScraper) fetchPageScript(url string, page int) (string, error) {
+	body, err := json.Marshal(&transactionListRequest{
+		AccountList: []transactionListAccount{{AccountNumberM24: cs.accountNumber}},
+		FilterList:  []interface{}{},
+		SortList:    []transactionListSort{{Direction: "DESC", Property: "ACCOUNTINGDATE", Order: 1}},
+		Paging:      transactionListPaging{RowsPerPage: CSOB_ROWS_PER_PAGE, PageNumber: page},
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`fetch(%q, {method: "POST", credentials: "include", headers: {"Content-Type": "application/json"}, body: %q}).then(r => { if (!r.ok) throw new Error("status code: " + r.status); return r.text() })`, url, body), nil
+}
 
-	payments := *pReference
+// collectPages requests transaction pages 1..PageCount one by one until all
+// of them are received or a page reaches lastAccountingOrder, pages which
+// weren't requested are ignored
+func collectPages(ctx context.Context, pages <-chan transactionPage, lastAccountingOrder int, requestPage func(page int) error) ([]Payment, error) {
+	var payments []Payment
+	pageCount := 1
+	for page := 1; page <= pageCount && page <= CSOB_MAX_PAGES; page++ {
+		if err := requestPage(page); err != nil {
+			log.Printf("Can't request transaction page %d: %v", page, err)
+			return nil, err
+		}
+		response, err := waitForPage(ctx, pages, page)
+		if err != nil {
+			return nil, err
+		}
+		pageCount = response.Paging.PageCount
+		payments = append(payments, processTransactions(response.AccountedTransaction, lastAccountingOrder)...)
+		if reachedOrder(response.AccountedTransaction, lastAccountingOrder) {
+			break
+		}
+	}
 
 	sort.Slice(payments, func(i, j int) bool {
 		return payments[i].Order < payments[j].Order
 	})
-
 	return payments, nil
 }
 
-func listenForNetworkEvent(ctx context.Context, lastAccountingOrder int) *[]Payment {
-	var payments []Payment
+// waitForPage returns the requested page, other pages are skipped
+func waitForPage(ctx context.Context, pages <-chan transactionPage, page int) (*bankResponse, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("transaction page %d not received: %w", page, ctx.Err())
+		case result := <-pages:
+			if result.page != page {
+				log.Printf("Skipping transaction page %d, waiting for %d", result.page, page)
+				continue
+			}
+			if result.err != nil {
+				log.Printf("Can't get transaction page %d: %v", page, result.err)
+				return nil, result.err
+			}
+			if result.response.Paging.PageNumber != page {
+				log.Printf("Skipping transaction page numbered %d, waiting for %d", result.response.Paging.PageNumber, page)
+				continue
+			}
+			return &result.response, nil
+		}
+	}
+}
+
+// listenForNetworkEvent sends URL of the transaction list the page requests to
+// urls, the response of the page itself isn't used
+func listenForNetworkEvent(ctx context.Context, urls chan<- string) {
 	chromedp.ListenTarget(ctx, func(ev interface{}) {
 		switch ev := ev.(type) {
 
-		case *network.EventResponseReceived:
-			resp := ev.Response
-			if !strings.Contains(resp.URL, "transactionList") {
+		case *network.EventRequestWillBeSent:
+			if !strings.Contains(ev.Request.URL, "transactionList") {
 				return
 			}
-			go func() {
-				c := chromedp.FromContext(ctx)
-				rbp := network.GetResponseBody(ev.RequestID)
-				body, err := rbp.Do(cdp.WithExecutor(ctx, c.Target))
-				if err != nil {
-					return
-				}
-				bankResponse := bankResponse{}
-
-				err = json.Unmarshal(body, &bankResponse)
-				if err != nil {
-					log.Printf("Can't unmarshal bank response body err: %v\nbody: %v, url: %s", err, string(body), resp.URL)
-					return
-				}
-
-				if bankResponse.Paging.PageNumber > 0 {
-					payments = append(payments, processTransactions(bankResponse.AccountedTransaction, lastAccountingOrder)...)
-				}
-			}()
+			// the listener must not block, only the first URL is needed
+			select {
+			case urls <- ev.Request.URL:
+			default:
+			}
 		}
 	})
-	return &payments
 }
 
 func processTransactions(transactions []transaction, lastAccountingOrder int) []Payment {
@@ -238,9 +308,10 @@ func processTransactions(transactions []transaction, lastAccountingOrder int) []
 					transaction.TransactionTypeChoice.DomesticPayment.PartyAccount.DomesticAccount.AccountNumber,
 					transaction.TransactionTypeChoice.DomesticPayment.PartyAccount.DomesticAccount.BankCode,
 				),
-				Message: transaction.TransactionTypeChoice.DomesticPayment.Message.Message1,
-				Amount:  int(transaction.BaseInfo.AccountAmountData.Amount),
-				Order:   transaction.BaseInfo.AccountingOrder,
+				Message:        transaction.TransactionTypeChoice.DomesticPayment.Message.Message1,
+				VariableSymbol: strings.TrimLeft(transaction.TransactionTypeChoice.DomesticPayment.Symbols.VariableSymbol, "0"),
+				Amount:         int(transaction.BaseInfo.AccountAmountData.Amount),
+				Order:          transaction.BaseInfo.AccountingOrder,
 			}
 			accountingDate, err := time.Parse("2006-01-02T15:04:05.000Z", transaction.BaseInfo.AccountingDate)
 			if err != nil {
@@ -253,35 +324,3 @@ func processTransactions(transactions []transaction, lastAccountingOrder int) []
 	}
 	return payments
 }
-
-func (cc *CsobClient) paymentsSinceLastCheckFromFile(lastAccountingOrder int, fileName string) ([]Payment, error) {
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		log.Printf("File not found: %s", fileName)
-		return nil, err
-	}
-
-	file, err := os.Open(fileName)
-	if err != nil {
-		log.Printf("Can't open file: %s, err: %v", fileName, err)
-		return nil, err
-	}
-	defer os.Remove(fileName)
-	defer file.Close()
-
-	fileBytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		log.Printf("Can't read file: %s, err: %v", fileName, err)
-		return nil, err
-	}
-
-	bankResponse := bankResponse{}
-	err = json.Unmarshal(fileBytes, &bankResponse)
-	if err != nil {
-		log.Printf("Can't unmarshal file: %s, err: %v", fileName, err)
-		return nil, err
-	}
-
-	payments := processTransactions(bankResponse.AccountedTransaction, lastAccountingOrder)
-
-	return payments, nil
-}
@@ -0,0 +1,181 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func loadPage(t *testing.T, number int) bankResponse {
+	t.Helper()
+	data, err := os.ReadFile(fmt.Sprintf("testdata/csob_transactions_page%d.json", number))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := bankResponse{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestCollectPages(t *testing.T) {
+	page0, page1, page2, page3 := loadPage(t, 0), loadPage(t, 1), loadPage(t, 2), loadPage(t, 3)
+	fetchErr := errors.New("status code: 500")
+	requestErr := errors.New("browser closed")
+
+	tests := []struct {
+		name                string
+		lastAccountingOrder int
+		// pages sent to the channel after the page is requested
+		deliveries     map[int][]transactionPage
+		requestErrPage int
+		wantOrders     []int
+		wantRequested  []int
+		wantErr        error
+		wantTimeout    bool
+	}{
+		{
+			name: "all pages",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+				2: {{page: 2, response: page2}},
+				3: {{page: 3, response: page3}},
+			},
+			wantOrders:    []int{1001, 1002, 1003, 1004, 1006},
+			wantRequested: []int{1, 2, 3},
+		},
+		{
+			name:                "stops at last accounting order",
+			lastAccountingOrder: 1003,
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+				2: {{page: 2, response: page2}},
+				3: {{page: 3, response: page3}},
+			},
+			wantOrders:    []int{1004, 1006},
+			wantRequested: []int{1, 2},
+		},
+		{
+			name: "stray page zero",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 0, response: page0}, {page: 1, response: page0}, {page: 1, response: page1}},
+				2: {{page: 2, response: page2}},
+				3: {{page: 3, response: page3}},
+			},
+			wantOrders:    []int{1001, 1002, 1003, 1004, 1006},
+			wantRequested: []int{1, 2, 3},
+		},
+		{
+			name: "duplicate page",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+				2: {{page: 1, response: page1}, {page: 2, response: page2}},
+				3: {{page: 2, response: page2}, {page: 3, response: page3}},
+			},
+			wantOrders:    []int{1001, 1002, 1003, 1004, 1006},
+			wantRequested: []int{1, 2, 3},
+		},
+		{
+			name: "fetch error",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+				2: {{page: 2, err: fetchErr}},
+			},
+			wantRequested: []int{1, 2},
+			wantErr:       fetchErr,
+		},
+		{
+			name: "request error",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+			},
+			requestErrPage: 2,
+			wantRequested:  []int{1, 2},
+			wantErr:        requestErr,
+		},
+		{
+			name: "missing page",
+			deliveries: map[int][]transactionPage{
+				1: {{page: 1, response: page1}},
+				2: {{page: 2, response: page2}},
+			},
+			wantRequested: []int{1, 2, 3},
+			wantTimeout:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			pages := make(chan transactionPage)
+			var requested []int
+			payments, err := collectPages(ctx, pages, tt.lastAccountingOrder, func(page int) error {
+				requested = append(requested, page)
+				if page == tt.requestErrPage {
+					return requestErr
+				}
+				go func() {
+					for _, delivery := range tt.deliveries[page] {
+						select {
+						case pages <- delivery:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}()
+				return nil
+			})
+
+			if !reflect.DeepEqual(requested, tt.wantRequested) {
+				t.Errorf("requested pages = %v, want %v", requested, tt.wantRequested)
+			}
+			switch {
+			case tt.wantTimeout:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var orders []int
+			for _, payment := range payments {
+				orders = append(orders, payment.Order)
+			}
+			if !reflect.DeepEqual(orders, tt.wantOrders) {
+				t.Errorf("payment orders = %v, want %v", orders, tt.wantOrders)
+			}
+		})
+	}
+}
+
+func TestProcessTransactions(t *testing.T) {
+	payments := processTransactions(loadPage(t, 1).AccountedTransaction, 0)
+	if len(payments) != 1 {
+		t.Fatalf("got %d payments, want 1", len(payments))
+	}
+	want := Payment{
+		Name:           "NOVAK JAN",
+		AccountNumber:  "123456789/0800",
+		Message:        "hokej",
+		VariableSymbol: "1012",
+		Amount:         250,
+		Order:          1006,
+		Timestamp:      time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(payments[0], want) {
+		t.Errorf("payment = %+v, want %+v", payments[0], want)
+	}
+}
@@ -0,0 +1,125 @@
+package bank
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	CSOB_TRANSACTIONS_URL = "https://www.csob.cz/et-npw-lta-view/api/detail/transactionList"
+	CSOB_ROWS_PER_PAGE    = 50
+	CSOB_MAX_PAGES        = 100
+	CSOB_REQUEST_TIMEOUT  = 30 * time.Second
+)
+
+type transactionListRequest struct {
+	AccountList []transactionListAccount `json:"accountList"`
+	FilterList  []interface{}            `json:"filterList"`
+	SortList    []transactionListSort    `json:"sortList"`
+	Paging      transactionListPaging    `json:"paging"`
+}
+
+type transactionListAccount struct {
+	AccountNumberM24 int `json:"accountNumberM24"`
+}
+
+type transactionListSort struct {
+	Direction string `json:"direction"`
+	Property  string `json:"property"`
+	Order     int    `json:"order"`
+}
+
+type transactionListPaging struct {
+	RowsPerPage int `json:"rowsPerPage"`
+	PageNumber  int `json:"pageNumber"`
+}
+
+// NewCsobTransparentAccount creates source reading the transparent account
+// transaction list directly, the same endpoint the account web page calls
+func NewCsobTransparentAccount(accountNumber int) *CsobTransparentAccount {
+	return &CsobTransparentAccount{
+		accountNumber: accountNumber,
+		url:           CSOB_TRANSACTIONS_URL,
+		client:        &http.Client{Timeout: CSOB_REQUEST_TIMEOUT},
+	}
+}
+
+type CsobTransparentAccount struct {
+	accountNumber int
+	url           string
+	client        *http.Client
+}
+
+// PaymentsSinceLastCheck pages through transactions from the newest one
+// until it reaches lastAccountingOrder
+func (cta *CsobTransparentAccount) PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
+	var payments []Payment
+	for page := 1; page <= CSOB_MAX_PAGES; page++ {
+		response, err := cta.transactionList(page)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, processTransactions(response.AccountedTransaction, lastAccountingOrder)...)
+		if response.Paging.PageNumber >= response.Paging.PageCount || reachedOrder(response.AccountedTransaction, lastAccountingOrder) {
+			break
+		}
+	}
+
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].Order < payments[j].Order
+	})
+	return payments, nil
+}
+
+func (cta *CsobTransparentAccount) transactionList(page int) (*bankResponse, error) {
+	body, err := json.Marshal(&transactionListRequest{
+		AccountList: []transactionListAccount{{AccountNumberM24: cta.accountNumber}},
+		FilterList:  []interface{}{},
+		SortList:    []transactionListSort{{Direction: "DESC", Property: "ACCOUNTINGDATE", Order: 1}},
+		Paging:      transactionListPaging{RowsPerPage: CSOB_ROWS_PER_PAGE, PageNumber: page},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest("POST", cta.url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Can't create request %v\n", err)
+		return nil, err
+	}
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("Accept", "application/json")
+	response, err := cta.client.Do(r)
+	if err != nil {
+		log.Printf("Can't get transaction list: %v\n", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(response.Body)
+		log.Printf("Unexpected return code: %d, body: %s\n", response.StatusCode, string(responseBody))
+		return nil, fmt.Errorf("transaction list request failed, status code: %d", response.StatusCode)
+	}
+	bankResponse := bankResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&bankResponse); err != nil {
+		log.Printf("Can't unmarshal bank response: %v\n", err)
+		return nil, err
+	}
+	return &bankResponse, nil
+}
+
+// reachedOrder reports whether the page contains already known transactions,
+// the following pages are older
+func reachedOrder(transactions []transaction, lastAccountingOrder int) bool {
+	for _, transaction := range transactions {
+		if transaction.BaseInfo.AccountingOrder <= lastAccountingOrder {
+			return true
+		}
+	}
+	return false
+}
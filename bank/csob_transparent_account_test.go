@@ -0,0 +1,106 @@
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// transactionListStub serves the recorded transaction list pages by the
+// requested page number
+type transactionListStub struct {
+	mutex     sync.Mutex
+	requested []int
+	failPage  int
+}
+
+func (tls *transactionListStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := transactionListRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.AccountList) != 1 || request.AccountList[0].AccountNumberM24 != 123456789 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	page := request.Paging.PageNumber
+	tls.mutex.Lock()
+	tls.requested = append(tls.requested, page)
+	tls.mutex.Unlock()
+	if page == tls.failPage {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data, err := os.ReadFile(fmt.Sprintf("testdata/csob_transactions_page%d.json", page))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func TestCsobTransparentAccountPaymentsSinceLastCheck(t *testing.T) {
+	tests := []struct {
+		name                string
+		lastAccountingOrder int
+		failPage            int
+		wantOrders          []int
+		wantRequested       []int
+		wantErr             bool
+	}{
+		{
+			name:          "all pages",
+			wantOrders:    []int{1001, 1002, 1003, 1004, 1006},
+			wantRequested: []int{1, 2, 3},
+		},
+		{
+			name:                "stops at last accounting order",
+			lastAccountingOrder: 1003,
+			wantOrders:          []int{1004, 1006},
+			wantRequested:       []int{1, 2},
+		},
+		{
+			name:                "nothing new",
+			lastAccountingOrder: 1006,
+			wantRequested:       []int{1},
+		},
+		{
+			name:          "failed page",
+			failPage:      2,
+			wantRequested: []int{1, 2},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &transactionListStub{failPage: tt.failPage}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+			account := NewCsobTransparentAccount(123456789)
+			account.url = server.URL
+
+			payments, err := account.PaymentsSinceLastCheck(tt.lastAccountingOrder)
+			if !reflect.DeepEqual(stub.requested, tt.wantRequested) {
+				t.Errorf("requested pages = %v, want %v", stub.requested, tt.wantRequested)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", payments)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var orders []int
+			for _, payment := range payments {
+				orders = append(orders, payment.Order)
+			}
+			if !reflect.DeepEqual(orders, tt.wantOrders) {
+				t.Errorf("payment orders = %v, want %v", orders, tt.wantOrders)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	FIO_BASE_URL        = "https://fioapi.fio.cz/v1/rest"
+	FIO_DATE_FORMAT     = "2006-01-02-0700"
+	FIO_REQUEST_TIMEOUT = 30 * time.Second
+	// FIO_REQUEST_INTERVAL is the minimal delay between requests with one token
+	FIO_REQUEST_INTERVAL = 30 * time.Second
+)
+
+// fioColumn is a transaction field, Fio sends null for empty columns
+type fioColumn struct {
+	Value interface{} `json:"value"`
+	Name  string      `json:"name"`
+	ID    int         `json:"id"`
+}
+
+type fioTransaction struct {
+	Date           *fioColumn `json:"column0"`
+	Amount         *fioColumn `json:"column1"`
+	CounterAccount *fioColumn `json:"column2"`
+	BankCode       *fioColumn `json:"column3"`
+	VariableSymbol *fioColumn `json:"column5"`
+	UserReference  *fioColumn `json:"column7"`
+	CounterName    *fioColumn `json:"column10"`
+	Currency       *fioColumn `json:"column14"`
+	Message        *fioColumn `json:"column16"`
+	ID             *fioColumn `json:"column22"`
+	Comment        *fioColumn `json:"column25"`
+}
+
+type fioResponse struct {
+	AccountStatement struct {
+		TransactionList struct {
+			Transaction []fioTransaction `json:"transaction"`
+		} `json:"transactionList"`
+	} `json:"accountStatement"`
+}
+
+// NewFioClient creates source reading payments via Fio API, the token is
+// generated in the internet banking with read only permission
+func NewFioClient(baseURL, token string) *FioClient {
+	if baseURL == "" {
+		baseURL = FIO_BASE_URL
+	}
+	return &FioClient{
+		baseURL:  baseURL,
+		token:    token,
+		client:   &http.Client{Timeout: FIO_REQUEST_TIMEOUT},
+		interval: FIO_REQUEST_INTERVAL,
+	}
+}
+
+type FioClient struct {
+	baseURL  string
+	token    string
+	client   *http.Client
+	interval time.Duration
+}
+
+// PaymentsSinceLastCheck downloads transactions since the last download, the
+// Fio mark is set to lastAccountingOrder first, so payments of a failed check
+// are downloaded again by the next one. Movement ID is used as the accounting
+// order.
+func (fc *FioClient) PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
+	if lastAccountingOrder > 0 {
+		if err := fc.get(fmt.Sprintf("%s/set-last-id/%s/%d/", fc.baseURL, fc.token, lastAccountingOrder), nil); err != nil {
+			return nil, err
+		}
+		// Fio accepts one request per token in FIO_REQUEST_INTERVAL
+		time.Sleep(fc.interval)
+	}
+	fioResponse := fioResponse{}
+	if err := fc.get(fmt.Sprintf("%s/last/%s/transactions.json", fc.baseURL, fc.token), &fioResponse); err != nil {
+		return nil, err
+	}
+
+	var payments []Payment
+	for _, transaction := range fioResponse.AccountStatement.TransactionList.Transaction {
+		payment, err := transaction.payment()
+		if err != nil {
+			log.Printf("Skipping Fio transaction: %v", err)
+			continue
+		}
+		if payment.Order > lastAccountingOrder && payment.Amount > 0 {
+			payments = append(payments, payment)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].Order < payments[j].Order
+	})
+	return payments, nil
+}
+
+// get calls Fio API and decodes the JSON response to result when it's not nil
+func (fc *FioClient) get(url string, result interface{}) error {
+	response, err := fc.client.Get(url)
+	if err != nil {
+		log.Printf("Fio API request error: %v\n", err)
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(response.Body)
+		log.Printf("Unexpected return code: %d, body: %s\n", response.StatusCode, string(responseBody))
+		// 409 means the API was called sooner than 30 seconds after the last call
+		return fmt.Errorf("Fio API request failed, status code: %d", response.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		log.Printf("Can't unmarshal Fio response: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func (ft *fioTransaction) payment() (Payment, error) {
+	p := Payment{
+		Name:           ft.CounterName.String(),
+		Message:        ft.Message.String(),
+		VariableSymbol: ft.VariableSymbol.String(),
+		Amount:         int(ft.Amount.Float()),
+		Order:          int(ft.ID.Float()),
+		TransactionID:  ft.ID.String(),
+	}
+	if p.Name == "" {
+		p.Name = ft.UserReference.String()
+	}
+	if p.Message == "" {
+		p.Message = ft.Comment.String()
+	}
+	if account := ft.CounterAccount.String(); account != "" {
+		p.AccountNumber = account
+		if bankCode := ft.BankCode.String(); bankCode != "" {
+			p.AccountNumber = fmt.Sprintf("%s/%s", account, bankCode)
+		}
+	}
+	if currency := ft.Currency.String(); currency != "" && currency != "CZK" {
+		return p, fmt.Errorf("unsupported currency %s of transaction %d", currency, p.Order)
+	}
+	timestamp, err := time.Parse(FIO_DATE_FORMAT, ft.Date.String())
+	if err != nil {
+		log.Printf("Can't parse accounting date: %v", err)
+		timestamp = time.Now()
+	}
+	p.Timestamp = timestamp
+	return p, nil
+}
+
+func (fc *fioColumn) String() string {
+	if fc == nil || fc.Value == nil {
+		return ""
+	}
+	switch value := fc.Value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(fc.Value)
+}
+
+func (fc *fioColumn) Float() float64 {
+	if fc == nil {
+		return 0
+	}
+	switch value := fc.Value.(type) {
+	case float64:
+		return value
+	case string:
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	}
+	return 0
+}
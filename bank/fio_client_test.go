@@ -0,0 +1,156 @@
+package bank
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testFioToken = "TOKEN"
+
+// fioStub serves recorded Fio responses and records the requested paths
+type fioStub struct {
+	mutex      sync.Mutex
+	requests   []string
+	lastStatus int
+	setStatus  int
+	body       []byte
+}
+
+func (fs *fioStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mutex.Lock()
+	fs.requests = append(fs.requests, r.URL.Path)
+	fs.mutex.Unlock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/set-last-id/"+testFioToken+"/"):
+		w.WriteHeader(fs.setStatus)
+	case r.URL.Path == "/last/"+testFioToken+"/transactions.json":
+		w.WriteHeader(fs.lastStatus)
+		w.Write(fs.body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestFioPaymentsSinceLastCheck(t *testing.T) {
+	body, err := os.ReadFile("testdata/fio_last.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                string
+		lastAccountingOrder int
+		setStatus           int
+		lastStatus          int
+		wantRequests        []string
+		wantOrders          []int
+		wantErr             bool
+	}{
+		{
+			name:         "first download",
+			setStatus:    http.StatusOK,
+			lastStatus:   http.StatusOK,
+			wantRequests: []string{"/last/TOKEN/transactions.json"},
+			wantOrders:   []int{26000000001, 26000000002, 26000000006},
+		},
+		{
+			name:                "mark is set to the last stored movement",
+			lastAccountingOrder: 26000000002,
+			setStatus:           http.StatusOK,
+			lastStatus:          http.StatusOK,
+			wantRequests:        []string{"/set-last-id/TOKEN/26000000002/", "/last/TOKEN/transactions.json"},
+			wantOrders:          []int{26000000006},
+		},
+		{
+			name:                "too many requests",
+			lastAccountingOrder: 26000000002,
+			setStatus:           http.StatusOK,
+			lastStatus:          http.StatusConflict,
+			wantRequests:        []string{"/set-last-id/TOKEN/26000000002/", "/last/TOKEN/transactions.json"},
+			wantErr:             true,
+		},
+		{
+			name:                "mark not set",
+			lastAccountingOrder: 26000000002,
+			setStatus:           http.StatusInternalServerError,
+			lastStatus:          http.StatusOK,
+			wantRequests:        []string{"/set-last-id/TOKEN/26000000002/"},
+			wantErr:             true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &fioStub{setStatus: tt.setStatus, lastStatus: tt.lastStatus, body: body}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+			client := NewFioClient(server.URL, testFioToken)
+			client.interval = 0
+
+			payments, err := client.PaymentsSinceLastCheck(tt.lastAccountingOrder)
+			if !reflect.DeepEqual(stub.requests, tt.wantRequests) {
+				t.Errorf("requests = %v, want %v", stub.requests, tt.wantRequests)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", payments)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var orders []int
+			for _, payment := range payments {
+				orders = append(orders, payment.Order)
+			}
+			if !reflect.DeepEqual(orders, tt.wantOrders) {
+				t.Errorf("payment orders = %v, want %v", orders, tt.wantOrders)
+			}
+		})
+	}
+}
+
+func TestFioTransactionPayment(t *testing.T) {
+	body, err := os.ReadFile("testdata/fio_last.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &fioStub{setStatus: http.StatusOK, lastStatus: http.StatusOK, body: body}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client := NewFioClient(server.URL, testFioToken)
+
+	payments, err := client.PaymentsSinceLastCheck(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Payment{
+		{
+			Name:           "Novák Jan",
+			AccountNumber:  "2000145399/0800",
+			Message:        "hokej",
+			VariableSymbol: "1012",
+			TransactionID:  "26000000001",
+			Amount:         250,
+			Order:          26000000001,
+			Timestamp:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.FixedZone("", 2*60*60)),
+		},
+		{
+			// user identification and comment are used when name and message
+			// are missing
+			Name:          "Svoboda",
+			AccountNumber: "987654321/0300",
+			Message:       "pivo",
+			TransactionID: "26000000002",
+			Amount:        300,
+			Order:         26000000002,
+			Timestamp:     time.Date(2024, 10, 2, 0, 0, 0, 0, time.FixedZone("", 2*60*60)),
+		},
+	}
+	assertPayments(t, payments[:2], want)
+}
@@ -0,0 +1,93 @@
+package bank
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+)
+
+type Payment struct {
+	Name           string
+	AccountNumber  string
+	Message        string
+	VariableSymbol string
+	// TransactionID is the bank reference of the transaction, it's set when
+	// the source provides other ID than the accounting order
+	TransactionID string
+	Amount        int
+	Order         int
+	Timestamp     time.Time
+}
+
+// PaymentSource provides incoming payments of the team account
+type PaymentSource interface {
+	// PaymentsSinceLastCheck returns payments with accounting order greater
+	// than lastAccountingOrder sorted by the order
+	PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error)
+}
+
+// NewFallbackSource creates source trying the sources in order until one of
+// them succeeds
+func NewFallbackSource(sources ...PaymentSource) *FallbackSource {
+	return &FallbackSource{
+		sources: sources,
+	}
+}
+
+type FallbackSource struct {
+	sources []PaymentSource
+}
+
+func (fs *FallbackSource) PaymentsSinceLastCheck(lastAccountingOrder int) ([]Payment, error) {
+	err := errors.New("no payment source")
+	for _, source := range fs.sources {
+		var payments []Payment
+		payments, err = source.PaymentsSinceLastCheck(lastAccountingOrder)
+		if err == nil {
+			return payments, nil
+		}
+		log.Printf("Payment source %T failed: %v", source, err)
+	}
+	return nil, err
+}
+
+// CheckPayments gets payments since the last stored one from the source and
+// stores them to DB
+func CheckPayments(source PaymentSource, db *database.Client) ([]Payment, error) {
+	previousLastAccountingOrder, err := db.GetLastPaymentOrder()
+	if err != nil {
+		log.Printf("Can't get last accounting order: %v", err)
+		return nil, err
+	}
+	log.Printf("Getting payments since: %d", previousLastAccountingOrder)
+	payments, err := source.PaymentsSinceLastCheck(previousLastAccountingOrder)
+	if err != nil {
+		log.Printf("Can't get payments: %v", err)
+		return nil, err
+	}
+	if err := storePayments(payments, db); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// paymentStore is the part of DB used to store payments
+type paymentStore interface {
+	StorePayment(name, account, message, variableSymbol, transactionID string, amount, order int, timestamp time.Time) error
+	PaymentExists(order int, transactionID string) (bool, error)
+	CountMatchingPayments(account, variableSymbol string, amount int, timestamp time.Time) (int, error)
+	NextImportedPaymentOrder() (int, error)
+}
+
+func storePayments(payments []Payment, db paymentStore) error {
+	for _, payment := range payments {
+		err := db.StorePayment(payment.Name, payment.AccountNumber, payment.Message, payment.VariableSymbol, payment.TransactionID, payment.Amount, payment.Order, payment.Timestamp)
+		if err != nil {
+			log.Printf("Can't store payment: %v", err)
+			return err
+		}
+	}
+	return nil
+}
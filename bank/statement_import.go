@@ -0,0 +1,419 @@
+package bank
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vlcak/groupme_qr_bot/bankaccount"
+	"github.com/vlcak/groupme_qr_bot/utils"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	STATEMENT_CAMT053   = "camt.053"
+	STATEMENT_GPC       = "gpc"
+	STATEMENT_CSV       = "csv"
+	STATEMENT_CSOB_JSON = "csob-json"
+
+	GPC_HEADER      = "074"
+	GPC_TRANSACTION = "075"
+	GPC_MESSAGE     = "078"
+	GPC_DATE_FORMAT = "020106"
+)
+
+// ImportStatement parses bank statement and stores payments which aren't
+// stored yet, it returns the new payments and the number of skipped ones.
+// Payments without the ČSOB accounting order are matched by the transaction ID
+// and get negative order, so they never move the last checked ČSOB order.
+// Payments stored from the ČSOB API have no transaction ID, so statement rows
+// not found by it are matched by account, amount, variable symbol and date.
+func ImportStatement(data []byte, db paymentStore) ([]Payment, int, error) {
+	payments, err := ParseStatement(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	nextOrder, err := db.NextImportedPaymentOrder()
+	if err != nil {
+		log.Printf("Can't get imported payment order: %v", err)
+		return nil, 0, err
+	}
+	var newPayments []Payment
+	skipped := 0
+	// stored matches already used by previous rows of the statement, so two
+	// same payments in one day are both imported when only one is stored
+	usedMatches := map[string]int{}
+	for _, payment := range payments {
+		if payment.Order == 0 && payment.TransactionID == "" {
+			return nil, 0, fmt.Errorf("missing transaction ID of payment: %s %d", payment.Name, payment.Amount)
+		}
+		exists, err := db.PaymentExists(payment.Order, payment.TransactionID)
+		if err != nil {
+			log.Printf("Can't check payment: %v", err)
+			return nil, 0, err
+		}
+		if !exists && payment.Order == 0 {
+			matches, err := db.CountMatchingPayments(payment.AccountNumber, payment.VariableSymbol, payment.Amount, payment.Timestamp)
+			if err != nil {
+				log.Printf("Can't check payment: %v", err)
+				return nil, 0, err
+			}
+			key := fmt.Sprintf("%s|%s|%d|%s", payment.AccountNumber, payment.VariableSymbol, payment.Amount, payment.Timestamp.Format("2006-01-02"))
+			if usedMatches[key] < matches {
+				usedMatches[key]++
+				exists = true
+			}
+		}
+		if exists {
+			skipped++
+			continue
+		}
+		if payment.Order == 0 {
+			payment.Order = nextOrder
+			nextOrder--
+		}
+		newPayments = append(newPayments, payment)
+	}
+	if err := storePayments(newPayments, db); err != nil {
+		return nil, 0, err
+	}
+	return newPayments, skipped, nil
+}
+
+// ParseStatement detects the statement format and returns incoming payments
+func ParseStatement(data []byte) ([]Payment, error) {
+	data = toUTF8(data)
+	var payments []Payment
+	var err error
+	switch format := StatementFormat(data); format {
+	case STATEMENT_CAMT053:
+		payments, err = parseCAMT053(data)
+	case STATEMENT_GPC:
+		payments, err = parseGPC(data)
+	case STATEMENT_CSOB_JSON:
+		payments, err = parseCsobJSON(data)
+	default:
+		payments, err = parseCSV(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var incoming []Payment
+	for _, payment := range payments {
+		if payment.Amount > 0 {
+			incoming = append(incoming, payment)
+		}
+	}
+	return incoming, nil
+}
+
+func StatementFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("BkToCstmrStmt")):
+		return STATEMENT_CAMT053
+	case bytes.HasPrefix(trimmed, []byte(GPC_HEADER)):
+		return STATEMENT_GPC
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return STATEMENT_CSOB_JSON
+	}
+	return STATEMENT_CSV
+}
+
+// toUTF8 converts statements exported in Windows-1250, which is common for
+// Czech banks
+func toUTF8(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return data
+	}
+	decoded, err := charmap.Windows1250.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtEntry struct {
+	Amount       string `xml:"Amt"`
+	Indicator    string `xml:"CdtDbtInd"`
+	BookingDate  string `xml:"BookgDt>Dt"`
+	BookingTime  string `xml:"BookgDt>DtTm"`
+	Reference    string `xml:"AcctSvcrRef"`
+	Transactions []struct {
+		Reference      string `xml:"Refs>AcctSvcrRef"`
+		EndToEndID     string `xml:"Refs>EndToEndId"`
+		DebtorName     string `xml:"RltdPties>Dbtr>Nm"`
+		DebtorIBAN     string `xml:"RltdPties>DbtrAcct>Id>IBAN"`
+		DebtorAccount  string `xml:"RltdPties>DbtrAcct>Id>Othr>Id"`
+		Unstructured   string `xml:"RmtInf>Ustrd"`
+		CreditorRef    string `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+		AdditionalInfo string `xml:"AddtlTxInf"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+func parseCAMT053(data []byte) ([]Payment, error) {
+	document := camtDocument{}
+	if err := xml.Unmarshal(data, &document); err != nil {
+		log.Printf("Can't parse CAMT.053 statement: %v", err)
+		return nil, err
+	}
+	var payments []Payment
+	for _, statement := range document.Statements {
+		for _, entry := range statement.Entries {
+			if entry.Indicator != "CRDT" {
+				continue
+			}
+			amount, err := strconv.ParseFloat(strings.TrimSpace(entry.Amount), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount %q: %v", entry.Amount, err)
+			}
+			p := Payment{
+				Amount:        int(amount),
+				TransactionID: strings.TrimSpace(entry.Reference),
+				Timestamp:     parseDate(entry.BookingDate, entry.BookingTime),
+			}
+			if len(entry.Transactions) > 0 {
+				tx := entry.Transactions[0]
+				if p.TransactionID == "" {
+					p.TransactionID = strings.TrimSpace(tx.Reference)
+				}
+				p.Name = strings.TrimSpace(tx.DebtorName)
+				p.AccountNumber = strings.TrimSpace(tx.DebtorAccount)
+				if iban := strings.TrimSpace(tx.DebtorIBAN); iban != "" {
+					p.AccountNumber = domesticAccount(iban)
+				}
+				p.Message = strings.TrimSpace(tx.Unstructured)
+				if p.Message == "" {
+					p.Message = strings.TrimSpace(tx.AdditionalInfo)
+				}
+				p.VariableSymbol = variableSymbol(tx.EndToEndID, tx.CreditorRef)
+			}
+			payments = append(payments, p)
+		}
+	}
+	return payments, nil
+}
+
+// parseGPC parses the fixed width ABO/GPC format, 075 records are
+// transactions optionally followed by 078 records with the message
+func parseGPC(data []byte) ([]Payment, error) {
+	var payments []Payment
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		runes := []rune(line)
+		field := func(from, to int) string {
+			if len(runes) < to {
+				return ""
+			}
+			// positions in the specification are 1-based and inclusive
+			return strings.TrimSpace(string(runes[from-1 : to]))
+		}
+		switch {
+		case strings.HasPrefix(line, GPC_TRANSACTION):
+			if len(runes) < 128 {
+				return nil, fmt.Errorf("invalid GPC transaction record: %q", line)
+			}
+			// 2 credit, 4 storno of debit, debits are kept as empty payments
+			// so their 078 records aren't assigned to the previous payment
+			code := field(61, 61)
+			if code != "2" && code != "4" {
+				payments = append(payments, Payment{})
+				continue
+			}
+			hellers, err := strconv.Atoi(field(49, 60))
+			if err != nil {
+				return nil, fmt.Errorf("invalid GPC amount: %q", field(49, 60))
+			}
+			p := Payment{
+				Name:           field(98, 117),
+				TransactionID:  field(36, 48),
+				Amount:         hellers / 100,
+				VariableSymbol: strings.TrimLeft(field(62, 71), "0"),
+			}
+			if account := gpcAccount(field(20, 35)); account != "" {
+				p.AccountNumber = fmt.Sprintf("%s/%s", account, field(74, 77))
+			}
+			if p.Timestamp, err = time.ParseInLocation(GPC_DATE_FORMAT, field(92, 97), time.Local); err != nil {
+				log.Printf("Can't parse accounting date: %v", err)
+				p.Timestamp = time.Now()
+			}
+			payments = append(payments, p)
+		case strings.HasPrefix(line, GPC_MESSAGE) && len(payments) > 0:
+			last := &payments[len(payments)-1]
+			last.Message = strings.TrimSpace(last.Message + " " + strings.TrimSpace(string(runes[3:])))
+		}
+	}
+	return payments, nil
+}
+
+// gpcAccount formats 16 digit GPC account as prefix-number, empty for zeros
+func gpcAccount(account string) string {
+	if strings.Trim(account, "0") == "" || len(account) != 16 {
+		return ""
+	}
+	prefix := strings.TrimLeft(account[:6], "0")
+	number := strings.TrimLeft(account[6:], "0")
+	if prefix == "" {
+		return number
+	}
+	return fmt.Sprintf("%s-%s", prefix, number)
+}
+
+func parseCsobJSON(data []byte) ([]Payment, error) {
+	bankResponse := bankResponse{}
+	if err := json.Unmarshal(data, &bankResponse); err != nil {
+		log.Printf("Can't unmarshal ČSOB statement: %v", err)
+		return nil, err
+	}
+	return processTransactions(bankResponse.AccountedTransaction, 0), nil
+}
+
+// csvColumns maps normalized header names of bank CSV exports to payment fields
+var csvColumns = map[string][]string{
+	"id":      {"id pohybu", "id transakce", "cislo transakce", "identifikace transakce", "poradi", "transaction id", "id"},
+	"date":    {"datum", "datum zauctovani", "datum zpracovani", "booking date", "date"},
+	"amount":  {"objem", "castka", "castka v mene uctu", "amount"},
+	"account": {"protiucet", "cislo protiuctu", "ucet protistrany", "counter account"},
+	"bank":    {"kod banky", "kod banky protiuctu", "bank code"},
+	"name":    {"nazev protiuctu", "jmeno protiuctu", "nazev protistrany", "counter account name"},
+	"vs":      {"vs", "variabilni symbol", "variable symbol"},
+	"message": {"zprava pro prijemce", "zprava", "poznamka", "message"},
+}
+
+var csvDateFormats = []string{"02.01.2006", "2.1.2006", "2006-01-02", "02.01.2006 15:04", "02.01.2006 15:04:05"}
+
+// parseCSV parses bank CSV exports, the header line is looked up by the
+// amount column as some banks put account information before it
+func parseCSV(data []byte) ([]Payment, error) {
+	delimiter := ','
+	if bytes.Count(data, []byte(";")) > bytes.Count(data, []byte(",")) {
+		delimiter = ';'
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		log.Printf("Can't parse CSV statement: %v", err)
+		return nil, err
+	}
+
+	var columns map[string]int
+	var payments []Payment
+	for _, record := range records {
+		if columns == nil {
+			columns = csvHeader(record)
+			continue
+		}
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		// outgoing payments
+		if strings.HasPrefix(value("amount"), "-") {
+			continue
+		}
+		amount, err := utils.ParseAmount(value("amount"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q: %v", value("amount"), err)
+		}
+		p := Payment{
+			Name:           value("name"),
+			AccountNumber:  value("account"),
+			Message:        value("message"),
+			VariableSymbol: value("vs"),
+			Amount:         int(amount),
+			TransactionID:  value("id"),
+		}
+		if bank := value("bank"); bank != "" && p.AccountNumber != "" && !strings.Contains(p.AccountNumber, "/") {
+			p.AccountNumber = fmt.Sprintf("%s/%s", p.AccountNumber, bank)
+		}
+		p.Timestamp = parseDate(value("date"), "")
+		payments = append(payments, p)
+	}
+	if columns == nil {
+		return nil, errors.New("CSV header with amount column not found")
+	}
+	return payments, nil
+}
+
+// csvHeader returns column indexes when the record is the header line
+func csvHeader(record []string) map[string]int {
+	columns := map[string]int{}
+	for i, header := range record {
+		header = utils.Normalize(strings.TrimSpace(header))
+		for name, headers := range csvColumns {
+			if _, ok := columns[name]; ok {
+				continue
+			}
+			for _, h := range headers {
+				if header == h {
+					columns[name] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["amount"]; !ok {
+		return nil
+	}
+	return columns
+}
+
+func parseDate(date, dateTime string) time.Time {
+	if t, err := time.Parse(time.RFC3339, dateTime); err == nil {
+		return t
+	}
+	for _, format := range csvDateFormats {
+		if t, err := time.ParseInLocation(format, strings.TrimSpace(date), time.Local); err == nil {
+			return t
+		}
+	}
+	log.Printf("Can't parse accounting date: %q", date)
+	return time.Now()
+}
+
+// domesticAccount converts Czech IBAN to the domestic format payments are
+// matched by, other IBANs are kept
+func domesticAccount(iban string) string {
+	account, err := bankaccount.FromIBAN(iban)
+	if err != nil {
+		return strings.ReplaceAll(iban, " ", "")
+	}
+	return account.String()
+}
+
+// variableSymbol extracts VS from CAMT references, Czech banks send it as
+// "VS1234" or "/VS/1234" in EndToEndId or as the creditor reference
+func variableSymbol(references ...string) string {
+	for _, reference := range references {
+		reference = strings.ToUpper(strings.TrimSpace(reference))
+		if i := strings.Index(reference, "VS"); i >= 0 {
+			digits := strings.TrimLeft(reference[i+2:], "/: ")
+			if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+				digits = digits[:end]
+			}
+			if digits != "" {
+				return strings.TrimLeft(digits, "0")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,325 @@
+package bank
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+		want   []Payment
+	}{
+		{
+			file:   "statement.camt053.xml",
+			format: STATEMENT_CAMT053,
+			want: []Payment{
+				{
+					Name:           "Novák Jan",
+					AccountNumber:  "19-2000145399/0800",
+					Message:        "hokej říjen",
+					VariableSymbol: "1012",
+					TransactionID:  "KB-2024100100001",
+					Amount:         250,
+					Timestamp:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.Local),
+				},
+				{
+					Name:           "Svoboda Petr",
+					AccountNumber:  "987654321/0300",
+					Message:        "pivo",
+					VariableSymbol: "1015",
+					TransactionID:  "0000001003",
+					Amount:         300,
+					Timestamp:      time.Date(2024, 10, 3, 8, 15, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			file:   "statement.gpc",
+			format: STATEMENT_GPC,
+			want: []Payment{
+				{
+					Name:           "NOVAK JAN",
+					AccountNumber:  "19-2000145399/0800",
+					Message:        "hokej rijen",
+					VariableSymbol: "1012",
+					TransactionID:  "0000000001001",
+					Amount:         250,
+					Timestamp:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.Local),
+				},
+				{
+					Name:          "SVOBODA PETR",
+					AccountNumber: "987654321/0300",
+					TransactionID: "0000000001003",
+					Amount:        300,
+					Timestamp:     time.Date(2024, 10, 3, 0, 0, 0, 0, time.Local),
+				},
+			},
+		},
+		{
+			file:   "statement.csv",
+			format: STATEMENT_CSV,
+			want: []Payment{
+				{
+					Name:           "Novák Jan",
+					AccountNumber:  "2000145399/0800",
+					Message:        "hokej",
+					VariableSymbol: "1012",
+					TransactionID:  "26000000001",
+					Amount:         1250,
+					Timestamp:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.Local),
+				},
+				{
+					AccountNumber: "19-2000145399/0800",
+					Message:       "pivo",
+					TransactionID: "26000000003",
+					Amount:        300,
+					Timestamp:     time.Date(2024, 10, 3, 0, 0, 0, 0, time.Local),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := StatementFormat(data); format != tt.format {
+				t.Errorf("format = %s, want %s", format, tt.format)
+			}
+			payments, err := ParseStatement(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertPayments(t, payments, tt.want)
+		})
+	}
+}
+
+func TestParseStatementWindows1250(t *testing.T) {
+	data, err := os.ReadFile("testdata/statement.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := charmap.Windows1250.NewEncoder().Bytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err := ParseStatement(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(payments) == 0 || payments[0].Name != "Novák Jan" {
+		t.Errorf("payments = %+v, want the first one from Novák Jan", payments)
+	}
+}
+
+func TestParseStatementErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"csv without amount column", "Datum;Zpráva\n01.10.2024;hokej\n"},
+		{"csv invalid amount", "ID pohybu;Objem\n1;abc\n"},
+		{"gpc short transaction", "074 header\n075123\n"},
+		{"invalid camt", "<Document><BkToCstmrStmt><Stmt><Ntry><Amt>x</Amt><CdtDbtInd>CRDT</CdtDbtInd></Ntry></Stmt></BkToCstmrStmt></Document>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if payments, err := ParseStatement([]byte(tt.data)); err == nil {
+				t.Errorf("expected error, got %+v", payments)
+			}
+		})
+	}
+}
+
+func TestGpcAccount(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+	}{
+		{"0000192000145399", "19-2000145399"},
+		{"0000000987654321", "987654321"},
+		{"0000000000000000", ""},
+		{"192000145399", ""},
+	}
+	for _, tt := range tests {
+		if got := gpcAccount(tt.account); got != tt.want {
+			t.Errorf("gpcAccount(%q) = %q, want %q", tt.account, got, tt.want)
+		}
+	}
+}
+
+func TestVariableSymbol(t *testing.T) {
+	tests := []struct {
+		references []string
+		want       string
+	}{
+		{[]string{"/VS/0000001012/SS/"}, "1012"},
+		{[]string{"", "VS1015"}, "1015"},
+		{[]string{"vs: 42"}, "42"},
+		{[]string{"NOTPROVIDED"}, ""},
+		{[]string{"VS/"}, ""},
+	}
+	for _, tt := range tests {
+		if got := variableSymbol(tt.references...); got != tt.want {
+			t.Errorf("variableSymbol(%q) = %q, want %q", tt.references, got, tt.want)
+		}
+	}
+}
+
+// memoryStore is in-memory paymentStore matching payments like the DB does
+type memoryStore struct {
+	payments []Payment
+}
+
+func (ms *memoryStore) StorePayment(name, account, message, variableSymbol, transactionID string, amount, order int, timestamp time.Time) error {
+	ms.payments = append(ms.payments, Payment{name, account, message, variableSymbol, transactionID, amount, order, timestamp})
+	return nil
+}
+
+func (ms *memoryStore) PaymentExists(order int, transactionID string) (bool, error) {
+	for _, payment := range ms.payments {
+		if (order != 0 && payment.Order == order) || (transactionID != "" && payment.TransactionID == transactionID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *memoryStore) CountMatchingPayments(account, variableSymbol string, amount int, timestamp time.Time) (int, error) {
+	withoutPrefix := func(account string) string {
+		if _, number, found := strings.Cut(account, "-"); found {
+			return number
+		}
+		return account
+	}
+	count := 0
+	for _, payment := range ms.payments {
+		diff := payment.Timestamp.Sub(timestamp)
+		if payment.TransactionID == "" && withoutPrefix(payment.AccountNumber) == withoutPrefix(account) &&
+			payment.VariableSymbol == variableSymbol && payment.Amount == amount && diff <= 24*time.Hour && diff >= -24*time.Hour {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (ms *memoryStore) NextImportedPaymentOrder() (int, error) {
+	order := 0
+	for _, payment := range ms.payments {
+		if payment.Order < order {
+			order = payment.Order
+		}
+	}
+	return order - 1, nil
+}
+
+func TestImportStatementTwice(t *testing.T) {
+	for _, file := range []string{"statement.camt053.xml", "statement.gpc", "statement.csv"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := &memoryStore{}
+			payments, skipped, err := ImportStatement(data, store)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(payments) != 2 || skipped != 0 {
+				t.Fatalf("first import stored %d, skipped %d, want 2 and 0", len(payments), skipped)
+			}
+			if payments[0].Order != -1 || payments[1].Order != -2 {
+				t.Errorf("imported orders = %d, %d, want -1, -2", payments[0].Order, payments[1].Order)
+			}
+			payments, skipped, err = ImportStatement(data, store)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(payments) != 0 || skipped != 2 {
+				t.Errorf("second import stored %d, skipped %d, want 0 and 2", len(payments), skipped)
+			}
+			if len(store.payments) != 2 {
+				t.Errorf("store has %d payments, want 2", len(store.payments))
+			}
+		})
+	}
+}
+
+func TestImportStatementAfterCsobAPI(t *testing.T) {
+	novak := Payment{
+		Name:           "NOVAK JAN",
+		AccountNumber:  "2000145399/0800",
+		Message:        "hokej rijen",
+		VariableSymbol: "1012",
+		Amount:         250,
+		Order:          1001,
+		Timestamp:      time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+	svoboda := Payment{
+		Name:           "SVOBODA PETR",
+		AccountNumber:  "987654321/0300",
+		VariableSymbol: "1015",
+		Amount:         300,
+		Order:          1003,
+		Timestamp:      time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name        string
+		stored      []Payment
+		wantNew     []string
+		wantSkipped int
+	}{
+		{"whole period stored", []Payment{novak, svoboda}, nil, 2},
+		{"part of period stored", []Payment{novak}, []string{"Svoboda Petr"}, 1},
+		{"other amount", []Payment{{Name: "NOVAK JAN", AccountNumber: "2000145399/0800", VariableSymbol: "1012", Amount: 200, Order: 1001, Timestamp: novak.Timestamp}}, []string{"Novák Jan", "Svoboda Petr"}, 0},
+		{"other day", []Payment{{Name: "NOVAK JAN", AccountNumber: "2000145399/0800", VariableSymbol: "1012", Amount: 250, Order: 1001, Timestamp: novak.Timestamp.AddDate(0, 0, -3)}}, []string{"Novák Jan", "Svoboda Petr"}, 0},
+	}
+	data, err := os.ReadFile("testdata/statement.camt053.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{}
+			if err := storePayments(tt.stored, store); err != nil {
+				t.Fatal(err)
+			}
+			payments, skipped, err := ImportStatement(data, store)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, payment := range payments {
+				names = append(names, payment.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNew) || skipped != tt.wantSkipped {
+				t.Errorf("imported %v, skipped %d, want %v, %d", names, skipped, tt.wantNew, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func assertPayments(t *testing.T, got, want []Payment) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d payments, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !got[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("payment %d timestamp = %v, want %v", i, got[i].Timestamp, want[i].Timestamp)
+		}
+		got[i].Timestamp, want[i].Timestamp = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("payment %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
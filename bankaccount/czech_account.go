@@ -0,0 +1,260 @@
+package bankaccount
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const (
+	COUNTRY_CODE  = "CZ"
+	PREFIX_LENGTH = 6
+	NUMBER_LENGTH = 10
+	IBAN_LENGTH   = 24
+)
+
+var (
+	domesticRegexp = regexp.MustCompile(`^(?:(\d{1,6})-)?(\d{2,10})/(\d{4})$`)
+	ibanRegexp     = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{1,30}$`)
+
+	prefixWeights = []int{10, 5, 8, 4, 2, 1}
+	numberWeights = []int{6, 3, 7, 9, 10, 5, 8, 4, 2, 1}
+)
+
+// BankCodes lists Czech bank codes from the ČNB code list, unknown codes are
+// accepted as new banks are added to the list, see KnownBank
+var BankCodes = map[string]string{
+	"0100": "Komerční banka",
+	"0300": "ČSOB",
+	"0600": "MONETA Money Bank",
+	"0710": "Česká národní banka",
+	"0800": "Česká spořitelna",
+	"2010": "Fio banka",
+	"2020": "MUFG Bank (Europe)",
+	"2060": "Citfin",
+	"2070": "TRINITY BANK",
+	"2100": "Hypoteční banka",
+	"2200": "Peněžní dům",
+	"2220": "Artesa",
+	"2250": "Banka CREDITAS",
+	"2260": "NEY spořitelní družstvo",
+	"2275": "Podnikatelská družstevní záložna",
+	"2600": "Citibank Europe",
+	"2700": "UniCredit Bank",
+	"3030": "Air Bank",
+	"3050": "BNP Paribas Personal Finance",
+	"3060": "PKO BP",
+	"3500": "ING Bank",
+	"4000": "Max banka",
+	"4300": "Národní rozvojová banka",
+	"5500": "Raiffeisenbank",
+	"5800": "J&T BANKA",
+	"6000": "PPF banka",
+	"6100": "Raiffeisenbank",
+	"6200": "COMMERZBANK",
+	"6210": "mBank",
+	"6300": "BNP Paribas",
+	"6363": "Partners Banka",
+	"6700": "Všeobecná úverová banka",
+	"7910": "Deutsche Bank",
+	"7940": "Waldviertler Sparkasse Bank",
+	"7950": "Raiffeisen stavební spořitelna",
+	"7960": "ČSOB Stavební spořitelna",
+	"7970": "MONETA Stavební Spořitelna",
+	"7990": "Modrá pyramida stavební spořitelna",
+	"8030": "Volksbank Raiffeisenbank Nordbayern",
+	"8040": "Oberbank",
+	"8060": "Stavební spořitelna České spořitelny",
+	"8090": "Česká exportní banka",
+	"8150": "HSBC Continental Europe",
+	"8190": "Sparkasse Oberlausitz-Niederschlesien",
+	"8198": "FAS finance company",
+	"8199": "MoneyPolo Europe",
+	"8200": "PRIVAT BANK der Raiffeisenlandesbank Oberösterreich",
+	"8220": "Payment execution",
+	"8230": "ABAPAY",
+	"8240": "Družstevní záložna Kredit",
+	"8250": "Bank of China",
+	"8255": "Bank of Communications",
+	"8265": "Industrial and Commercial Bank of China",
+	"8270": "Fairplay Pay",
+	"8280": "B-Efekt",
+	"8291": "Business Credit",
+	"8292": "Money Change",
+	"8293": "Mercurius partners",
+	"8299": "BESTPAY",
+	"8500": "Multitude Bank",
+}
+
+// Account is a Czech domestic account number in prefix-number/bank format
+type Account struct {
+	Prefix   string
+	Number   string
+	BankCode string
+}
+
+// Parse accepts domestic format ("19-2000145399/0800", "123456789/0300") or
+// Czech IBAN and validates the result
+func Parse(s string) (*Account, error) {
+	value := compact(s)
+	if strings.HasPrefix(strings.ToUpper(value), COUNTRY_CODE) {
+		return FromIBAN(value)
+	}
+	match := domesticRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("invalid account format: %s, expected prefix-number/bank", s)
+	}
+	a := &Account{
+		Prefix:   strings.TrimLeft(match[1], "0"),
+		Number:   strings.TrimLeft(match[2], "0"),
+		BankCode: match[3],
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Account) Validate() error {
+	if !checksum(pad(a.Prefix, PREFIX_LENGTH), prefixWeights) {
+		return fmt.Errorf("invalid account prefix: %s", a.Prefix)
+	}
+	// the number has to contain at least two non-zero digits
+	if len(strings.ReplaceAll(a.Number, "0", "")) < 2 {
+		return fmt.Errorf("invalid account number: %s", a.Number)
+	}
+	if !checksum(pad(a.Number, NUMBER_LENGTH), numberWeights) {
+		return fmt.Errorf("invalid account number: %s", a.Number)
+	}
+	return nil
+}
+
+// String returns the account in canonical domestic format without leading zeros
+func (a *Account) String() string {
+	if a.Prefix == "" {
+		return fmt.Sprintf("%s/%s", a.Number, a.BankCode)
+	}
+	return fmt.Sprintf("%s-%s/%s", a.Prefix, a.Number, a.BankCode)
+}
+
+// KnownBank reports whether the bank code is in the ČNB code list, accounts of
+// unknown banks are valid but the code is likely a typo
+func (a *Account) KnownBank() bool {
+	_, ok := BankCodes[a.BankCode]
+	return ok
+}
+
+func (a *Account) BankName() string {
+	return BankCodes[a.BankCode]
+}
+
+func (a *Account) IBAN() string {
+	bban := a.BankCode + pad(a.Prefix, PREFIX_LENGTH) + pad(a.Number, NUMBER_LENGTH)
+	return fmt.Sprintf("%s%02d%s", COUNTRY_CODE, ibanCheckDigits(COUNTRY_CODE, bban), bban)
+}
+
+// FromIBAN converts Czech IBAN to domestic account
+func FromIBAN(iban string) (*Account, error) {
+	value := strings.ToUpper(compact(iban))
+	if err := ValidateIBAN(value); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(value, COUNTRY_CODE) || len(value) != IBAN_LENGTH {
+		return nil, fmt.Errorf("not a Czech IBAN: %s", iban)
+	}
+	a := &Account{
+		BankCode: value[4:8],
+		Prefix:   strings.TrimLeft(value[8:14], "0"),
+		Number:   strings.TrimLeft(value[14:], "0"),
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ValidateIBAN checks format and mod-97 check digits of IBAN of any country
+func ValidateIBAN(iban string) error {
+	value := strings.ToUpper(compact(iban))
+	if !ibanRegexp.MatchString(value) {
+		return fmt.Errorf("invalid IBAN format: %s", iban)
+	}
+	if ibanRemainder(value[4:]+value[:4]) != 1 {
+		return fmt.Errorf("invalid IBAN checksum: %s", iban)
+	}
+	return nil
+}
+
+// Normalize validates the account and returns it in the format it should be
+// stored in - canonical domestic format for Czech accounts, compact IBAN for
+// foreign ones
+func Normalize(s string) (string, error) {
+	value := strings.ToUpper(compact(s))
+	if ibanRegexp.MatchString(value) && !strings.HasPrefix(value, COUNTRY_CODE) {
+		if err := ValidateIBAN(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+	a, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+// ToIBAN converts domestic account to IBAN, IBANs are validated and returned
+// in compact form
+func ToIBAN(s string) (string, error) {
+	value := strings.ToUpper(compact(s))
+	if ibanRegexp.MatchString(value) {
+		if err := ValidateIBAN(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+	a, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return a.IBAN(), nil
+}
+
+func checksum(digits string, weights []int) bool {
+	sum := 0
+	for i, d := range digits {
+		sum += int(d-'0') * weights[i]
+	}
+	return sum%11 == 0
+}
+
+func ibanCheckDigits(country, bban string) int {
+	return 98 - ibanRemainder(bban+country+"00")
+}
+
+// ibanRemainder converts letters to numbers (A=10 ... Z=35) and returns the
+// remainder after dividing by 97
+func ibanRemainder(value string) int {
+	var digits strings.Builder
+	for _, r := range value {
+		if r >= 'A' && r <= 'Z' {
+			digits.WriteString(fmt.Sprintf("%d", r-'A'+10))
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return -1
+	}
+	return int(new(big.Int).Mod(n, big.NewInt(97)).Int64())
+}
+
+func pad(digits string, length int) string {
+	return strings.Repeat("0", length-len(digits)) + digits
+}
+
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,138 @@
+package bankaccount
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+		wantErr bool
+	}{
+		{"19-2000145399/0800", "19-2000145399/0800", false},
+		{"000019-2000145399/0800", "19-2000145399/0800", false},
+		{" 2000145399 / 0800 ", "2000145399/0800", false},
+		{"CZ65 0800 0000 1920 0014 5399", "19-2000145399/0800", false},
+		// unknown bank codes are accepted
+		{"2000145399/9999", "2000145399/9999", false},
+		// mod 11 of the number
+		{"2000145398/0800", "", true},
+		// mod 11 of the prefix
+		{"18-2000145399/0800", "", true},
+		// at least two non-zero digits
+		{"1/0800", "", true},
+		{"10/0800", "", true},
+		{"2000145399", "", true},
+		{"2000145399/080", "", true},
+		{"CZ6608000000192000145399", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.account, func(t *testing.T) {
+			account, err := Parse(tt.account)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", account)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if account.String() != tt.want {
+				t.Errorf("account = %s, want %s", account, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownBank(t *testing.T) {
+	tests := []struct {
+		account string
+		want    bool
+	}{
+		{"19-2000145399/0800", true},
+		{"2000145399/9999", false},
+	}
+	for _, tt := range tests {
+		account, err := Parse(tt.account)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := account.KnownBank(); got != tt.want {
+			t.Errorf("KnownBank(%s) = %t, want %t", tt.account, got, tt.want)
+		}
+	}
+}
+
+func TestIBAN(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+	}{
+		{"19-2000145399/0800", "CZ6508000000192000145399"},
+		{"2000145399/0800", "CZ7908000000002000145399"},
+		{"CZ65 0800 0000 1920 0014 5399", "CZ6508000000192000145399"},
+		{"DE89 3704 0044 0532 0130 00", "DE89370400440532013000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.account, func(t *testing.T) {
+			iban, err := ToIBAN(tt.account)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if iban != tt.want {
+				t.Errorf("IBAN = %s, want %s", iban, tt.want)
+			}
+			if err := ValidateIBAN(iban); err != nil {
+				t.Errorf("generated IBAN is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateIBAN(t *testing.T) {
+	tests := []struct {
+		iban    string
+		wantErr bool
+	}{
+		{"CZ6508000000192000145399", false},
+		{"GB82 WEST 1234 5698 7654 32", false},
+		{"DE89370400440532013000", false},
+		// mod 97 check digits
+		{"CZ6608000000192000145399", true},
+		{"GB82WEST12345698765433", true},
+		{"CZ65", true},
+		{"6508000000192000145399", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.iban, func(t *testing.T) {
+			err := ValidateIBAN(tt.iban)
+			if tt.wantErr && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+	}{
+		{"0019-2000145399/0800", "19-2000145399/0800"},
+		{"CZ6508000000192000145399", "19-2000145399/0800"},
+		{"gb82 west 1234 5698 7654 32", "GB82WEST12345698765432"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.account)
+		if err != nil {
+			t.Errorf("Normalize(%q) unexpected error: %v", tt.account, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.account, got, tt.want)
+		}
+	}
+}
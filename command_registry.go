@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vlcak/groupme_qr_bot/groupme"
+	"github.com/vlcak/groupme_qr_bot/utils"
+)
+
+type ArgumentType int
+
+// amounts are often written with thousands separated by space, e.g. 1 250,50
+var (
+	amountLeadRegexp  = regexp.MustCompile(`^\d{1,3}$`)
+	amountGroupRegexp = regexp.MustCompile(`^\d{3}(,\d{1,2})?$`)
+)
+
+const (
+	ARG_WORD ArgumentType = iota
+	ARG_INT
+	ARG_AMOUNT
+	ARG_DATE
+	ARG_TIME
+	ARG_TEXT
+	// ARG_FLAG is a boolean set only by name, e.g. --dry-run
+	ARG_FLAG
+)
+
+// Argument describes a single positional argument of a command. ARG_TEXT
+// consumes the rest of the message, so it has to be the last argument.
+type Argument struct {
+	Name     string
+	Type     ArgumentType
+	Optional bool
+}
+
+type CommandArgs map[string]interface{}
+
+func (a CommandArgs) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a CommandArgs) String(name string) string {
+	if v, ok := a[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (a CommandArgs) Amount(name string) float64 {
+	if v, ok := a[name].(float64); ok {
+		return v
+	}
+	return 0
+}
+
+func (a CommandArgs) Bool(name string) bool {
+	if v, ok := a[name].(bool); ok {
+		return v
+	}
+	return false
+}
+
+func (a CommandArgs) Int(name string) int {
+	if v, ok := a[name].(int); ok {
+		return v
+	}
+	return 0
+}
+
+// CommandHandler runs the command, replies should be sent via ms which posts
+// to the group the command came from
+type CommandHandler func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Args        []Argument
+	Description string
+	// Role is the minimal role required to run the command, empty for everybody
+	Role string
+	// MaxConcurrent limits how many instances can be queued or running, 0 for no limit
+	MaxConcurrent int
+	// Immediate commands skip the job queue and run in the callback, so they
+	// answer even when the workers are busy
+	Immediate bool
+	Handler   CommandHandler
+}
+
+// Usage returns the command syntax, e.g. "PAY <amount> ?<perUser>"
+func (c *Command) Usage() string {
+	usage := c.Name
+	for _, arg := range c.Args {
+		if arg.Type == ARG_FLAG {
+			usage += fmt.Sprintf(" ?%s%s", utils.OPTION_PREFIX, arg.Name)
+		} else if arg.Optional {
+			usage += fmt.Sprintf(" ?<%s>", arg.Name)
+		} else {
+			usage += fmt.Sprintf(" <%s>", arg.Name)
+		}
+	}
+	return usage
+}
+
+// Parse validates the tokens following the command name against the declared
+// arguments and converts them to their types. Arguments can also be given by
+// name as --name=value options, positional tokens fill the remaining ones.
+func (c *Command) Parse(message *utils.TokenizedMessage, commandToken utils.Token) (CommandArgs, error) {
+	args := CommandArgs{}
+	named := map[string]utils.Token{}
+	for name, option := range message.Options {
+		found := false
+		for _, arg := range c.Args {
+			if strings.ToLower(arg.Name) == name {
+				named[arg.Name] = option
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, utils.NewParseError(utils.Token{Value: utils.OPTION_PREFIX + name, Position: option.Position}, "unknown option")
+		}
+	}
+
+	tokens := message.Tokens[1:]
+	for i, arg := range c.Args {
+		if option, ok := named[arg.Name]; ok {
+			value, err := arg.convert(option)
+			if err != nil {
+				return nil, err
+			}
+			args[arg.Name] = value
+			continue
+		}
+		if arg.Type == ARG_FLAG {
+			continue
+		}
+		if len(tokens) == 0 {
+			if arg.Optional {
+				continue
+			}
+			return nil, &utils.ParseError{
+				Position: commandToken.Position,
+				Reason:   fmt.Sprintf("missing <%s>", arg.Name),
+			}
+		}
+		if arg.Type == ARG_TEXT {
+			words := make([]string, len(tokens))
+			for i, token := range tokens {
+				words[i] = token.Value
+			}
+			args[arg.Name] = strings.Join(words, " ")
+			tokens = nil
+			continue
+		}
+		token, consumed := tokens[0], 1
+		if arg.Type == ARG_AMOUNT {
+			token, consumed = joinAmount(tokens, c.requiredAfter(i, named))
+		}
+		value, err := arg.convert(token)
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = value
+		tokens = tokens[consumed:]
+		if arg.Type == ARG_AMOUNT && len(tokens) > 0 && !tokens[0].Quoted && utils.IsCurrency(tokens[0].Value) {
+			tokens = tokens[1:]
+		}
+	}
+	if len(tokens) > 0 {
+		return nil, utils.NewParseError(tokens[0], "unexpected argument")
+	}
+	return args, nil
+}
+
+// requiredAfter counts positional arguments following the i-th one which need
+// a token
+func (c *Command) requiredAfter(i int, named map[string]utils.Token) int {
+	required := 0
+	for _, arg := range c.Args[i+1:] {
+		if _, ok := named[arg.Name]; !ok && !arg.Optional && arg.Type != ARG_FLAG {
+			required++
+		}
+	}
+	return required
+}
+
+// joinAmount joins the amount with the following digit groups, e.g. "1 250,50",
+// reserved tokens are left for the following arguments. It returns the joined
+// token and the number of tokens it spans.
+func joinAmount(tokens []utils.Token, reserved int) (utils.Token, int) {
+	if tokens[0].Quoted || !amountLeadRegexp.MatchString(tokens[0].Value) {
+		return tokens[0], 1
+	}
+	token := tokens[0]
+	consumed := 1
+	for consumed < len(tokens)-reserved && !tokens[consumed].Quoted && amountGroupRegexp.MatchString(tokens[consumed].Value) {
+		group := tokens[consumed].Value
+		token.Value += " " + group
+		consumed++
+		// decimal part ends the amount
+		if strings.Contains(group, ",") {
+			break
+		}
+	}
+	return token, consumed
+}
+
+func (a *Argument) convert(token utils.Token) (interface{}, error) {
+	switch a.Type {
+	case ARG_INT:
+		value, err := strconv.Atoi(token.Value)
+		if err != nil {
+			return nil, utils.NewParseError(token, fmt.Sprintf("<%s> is not a number", a.Name))
+		}
+		return value, nil
+	case ARG_AMOUNT:
+		value, err := utils.ParseAmount(token.Value)
+		if err != nil {
+			return nil, utils.NewParseError(token, fmt.Sprintf("<%s> is not an amount (%v)", a.Name, err))
+		}
+		return value, nil
+	case ARG_DATE:
+		if _, err := time.Parse("2006-01-02", token.Value); err != nil {
+			return nil, utils.NewParseError(token, fmt.Sprintf("<%s> is not a date (YYYY-MM-DD)", a.Name))
+		}
+	case ARG_TIME:
+		if _, err := time.Parse("15:04", token.Value); err != nil {
+			return nil, utils.NewParseError(token, fmt.Sprintf("<%s> is not a time (HH:MM)", a.Name))
+		}
+	case ARG_FLAG:
+		if token.Value == "" {
+			return true, nil
+		}
+		value, err := strconv.ParseBool(token.Value)
+		if err != nil {
+			return nil, utils.NewParseError(token, fmt.Sprintf("%s%s is not a boolean", utils.OPTION_PREFIX, a.Name))
+		}
+		return value, nil
+	}
+	return token.Value, nil
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: map[string]*Command{},
+	}
+}
+
+type CommandRegistry struct {
+	commands map[string]*Command
+	ordered  []*Command
+}
+
+func (cr *CommandRegistry) Register(command *Command) {
+	for _, name := range append([]string{command.Name}, command.Aliases...) {
+		if _, ok := cr.commands[name]; ok {
+			log.Fatalf("Command registered twice: %s\n", name)
+		}
+		cr.commands[name] = command
+	}
+	cr.ordered = append(cr.ordered, command)
+}
+
+// Lookup finds the command by its name or alias, case-insensitive
+func (cr *CommandRegistry) Lookup(name string) (*Command, bool) {
+	command, ok := cr.commands[strings.ToUpper(name)]
+	return command, ok
+}
+
+// Help lists all registered commands in the order they were registered
+func (cr *CommandRegistry) Help() string {
+	help := "Commands:\n"
+	for _, command := range cr.ordered {
+		if command.Role != "" {
+			help += fmt.Sprintf("%s - %s (%s)\n", command.Usage(), command.Description, command.Role)
+		} else {
+			help += fmt.Sprintf("%s - %s\n", command.Usage(), command.Description)
+		}
+	}
+	return strings.TrimSuffix(help, "\n")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vlcak/groupme_qr_bot/utils"
+)
+
+func TestParseAmountGroups(t *testing.T) {
+	qr := &Command{
+		Name: "QR",
+		Args: []Argument{
+			{Name: "amount", Type: ARG_AMOUNT},
+			{Name: "split", Type: ARG_INT},
+			{Name: "description", Type: ARG_TEXT},
+		},
+	}
+	tests := []struct {
+		text    string
+		want    CommandArgs
+		wantErr bool
+	}{
+		{"QR 250 4 pivo", CommandArgs{"amount": 250.0, "split": 4, "description": "pivo"}, false},
+		{"QR 1 250,50 Kč 4 pivo", CommandArgs{"amount": 1250.5, "split": 4, "description": "pivo"}, false},
+		{"QR 1 250 4 pivo", CommandArgs{"amount": 1250.0, "split": 4, "description": "pivo"}, false},
+		{"QR 1 250 000 2 led", CommandArgs{"amount": 1250000.0, "split": 2, "description": "led"}, false},
+		{"QR 1250,50 4 pivo", CommandArgs{"amount": 1250.5, "split": 4, "description": "pivo"}, false},
+		// groups are left for the required arguments
+		{"QR 1 250 pivo", CommandArgs{"amount": 1.0, "split": 250, "description": "pivo"}, false},
+		{"QR 1 250 --split=4 pivo", CommandArgs{"amount": 1250.0, "split": 4, "description": "pivo"}, false},
+		{`QR 1 "250" 4 pivo`, CommandArgs{"amount": 1.0, "split": 250, "description": "4 pivo"}, false},
+		{"QR 1 250,50 pivo", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			message, err := utils.Tokenize(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, err := qr.Parse(message, message.Tokens[0])
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("args = %v, want %v", args, tt.want)
+			}
+		})
+	}
+}
@@ -4,32 +4,66 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/adrg/strutil"
+	"github.com/adrg/strutil/metrics"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/vlcak/groupme_qr_bot/bank"
 	database "github.com/vlcak/groupme_qr_bot/db"
 	"github.com/vlcak/groupme_qr_bot/google"
 	"github.com/vlcak/groupme_qr_bot/groupme"
 	"github.com/vlcak/groupme_qr_bot/tymuj"
+	"github.com/vlcak/groupme_qr_bot/utils"
+	"golang.org/x/exp/slices"
 )
 
-func NewCronWorker(csobClient *bank.CsobClient, sheetOperator *google.SheetOperator, tymujClient *tymuj.Client, messageService *groupme.MessageService, db *database.Client) *CronWorker {
+const (
+	PROCESSED_MESSAGES_RETENTION = 30 * 24 * time.Hour
+
+	// payment matching rules, stored with processed payments
+	MATCH_VARIABLE_SYMBOL = "vs"
+	MATCH_ACCOUNT         = "account"
+	MATCH_RESENT          = "resent"
+	MATCH_FUZZY           = "fuzzy"
+	MATCH_NONE            = "none"
+
+	FUZZY_MATCH_THRESHOLD = 0.75
+)
+
+var resentRegexp = regexp.MustCompile(`^TO \d{9,10}/\d{4,4}`)
+
+func NewCronWorker(paymentSource bank.PaymentSource, csobClient *bank.CsobClient, ledger *Ledger, sheetOperator *google.SheetOperator, tymujClient *tymuj.Client, messageService *groupme.MessageService, directMessageService *groupme.DirectMessageService, imageService *groupme.ImageService, qrRenderer *utils.QRRenderer, db *database.Client, reminders DebtReminderConfig) *CronWorker {
 	return &CronWorker{
-		csobClient:     csobClient,
-		sheetOperator:  sheetOperator,
-		tymujClient:    tymujClient,
-		messageService: messageService,
-		db:             db,
+		ledger:               ledger,
+		paymentSource:        paymentSource,
+		csobClient:           csobClient,
+		sheetOperator:        sheetOperator,
+		tymujClient:          tymujClient,
+		messageService:       messageService,
+		directMessageService: directMessageService,
+		imageService:         imageService,
+		paymentGenerator:     utils.NewQRPaymentGenerator(qrRenderer),
+		db:                   db,
+		reminders:            reminders,
 	}
 }
 
 type CronWorker struct {
+	paymentSource  bank.PaymentSource
 	csobClient     *bank.CsobClient
+	ledger         *Ledger
 	sheetOperator  *google.SheetOperator
 	tymujClient    *tymuj.Client
 	messageService *groupme.MessageService
-	db             *database.Client
+	// directMessageService is nil when personal messages are disabled
+	directMessageService *groupme.DirectMessageService
+	imageService         *groupme.ImageService
+	paymentGenerator     *utils.QRPaymentGenerator
+	db                   *database.Client
+	reminders            DebtReminderConfig
 }
 
 func (cw *CronWorker) CheckNewPayments() {
@@ -37,7 +71,7 @@ func (cw *CronWorker) CheckNewPayments() {
 	exponentialBackoff := backoff.NewExponentialBackOff()
 	exponentialBackoff.MaxElapsedTime = 5 * time.Minute
 	payments, err := backoff.RetryNotifyWithData(func() ([]bank.Payment, error) {
-		return cw.csobClient.CheckPayments()
+		return bank.CheckPayments(cw.paymentSource, cw.db)
 	}, exponentialBackoff, func(err error, duration time.Duration) {
 		log.Printf("Can't get payments: %v, retrying in %s", err, duration)
 	})
@@ -50,14 +84,19 @@ func (cw *CronWorker) CheckNewPayments() {
 		}
 	}
 
-	userNames, err := cw.sheetOperator.Get("Sheet1!A1:1", "", false)
+	cw.processPayments(payments)
+}
+
+// ImportStatement imports payments from bank statement and processes the new
+// ones, it returns summary of the import
+func (cw *CronWorker) ImportStatement(data []byte) (string, error) {
+	payments, skipped, err := bank.ImportStatement(data, cw.db)
 	if err != nil {
-		log.Printf("Can't get user names: %v", err)
-		return
-	}
-	for _, payment := range payments {
-		cw.processPayment(payment, userNames)
+		log.Printf("Can't import statement: %v", err)
+		return "", err
 	}
+	cw.processPayments(payments)
+	return fmt.Sprintf("Imported payments: %d, already stored: %d", len(payments), skipped), nil
 }
 
 func (cw *CronWorker) CheckUnprocessedPayments() {
@@ -70,21 +109,19 @@ func (cw *CronWorker) CheckUnprocessedPayments() {
 
 	if len(payments) > 0 {
 		log.Printf("Unprocessed payments found - reprocessing: %d", len(payments))
-		userNames, err := cw.sheetOperator.Get("Sheet1!A1:1", "", false)
-		if err != nil {
-			log.Printf("Can't get user names: %v", err)
-			return
-		}
-
+		var bankPayments []bank.Payment
 		for _, payment := range payments {
 			log.Printf("Reprocessing payment - name: %s, account: %s, amount: %d", payment.Name.String, payment.Account.String, payment.Amount.Int64)
-			cw.processPayment(bank.Payment{
-				Name:          payment.Name.String,
-				AccountNumber: payment.Account.String,
-				Amount:        int(payment.Amount.Int64),
-				Order:         int(payment.Order.Int64),
-			}, userNames)
+			bankPayments = append(bankPayments, bank.Payment{
+				Name:           payment.Name.String,
+				AccountNumber:  payment.Account.String,
+				Message:        payment.Message.String,
+				VariableSymbol: payment.VariableSymbol.String,
+				Amount:         int(payment.Amount.Int64),
+				Order:          int(payment.Order.Int64),
+			})
 		}
+		cw.processPayments(bankPayments)
 	}
 }
 
@@ -146,60 +183,152 @@ func (cw *CronWorker) CreateWednesdayEventForGoalies() {
 	cw.messageService.SendMessage(fmt.Sprintf("Event created: %s", eventURL), "")
 }
 
+func (cw *CronWorker) ExpireProcessedMessages() {
+	log.Printf("Expiring processed messages")
+	deleted, err := cw.db.DeleteProcessedMessages(time.Now().Add(-PROCESSED_MESSAGES_RETENTION))
+	if err != nil {
+		log.Printf("Can't expire processed messages: %v", err)
+		return
+	}
+	log.Printf("Expired processed messages: %d", deleted)
+}
+
+// processPayments credits the payments to the ledger and regenerates the
+// sheet
+func (cw *CronWorker) processPayments(payments []bank.Payment) {
+	if len(payments) == 0 {
+		return
+	}
+	// payments stay unprocessed and are credited once the ledger is initialized
+	if err := cw.ledger.CheckInitialized(); err != nil {
+		log.Printf("Payments not processed: %v", err)
+		return
+	}
+	userNames, err := cw.ledger.PlayerNames()
+	if err != nil {
+		log.Printf("Can't get user names: %v", err)
+		return
+	}
+	for _, payment := range payments {
+		cw.processPayment(payment, userNames)
+	}
+	if err := cw.ledger.Project(); err != nil {
+		log.Printf("Can't regenerate the sheet: %v", err)
+		cw.messageService.SendMessage(fmt.Sprintf("Can't regenerate the sheet: %v", err), "")
+	}
+}
+
 func (cw *CronWorker) processPayment(payment bank.Payment, userNames []string) {
-	resent, err := regexp.MatchString(`^TO \d{9,10}/\d{4,4}`, payment.Message)
+	name, matchedBy := cw.matchPayment(&payment, userNames)
+
+	err := cw.ledger.Credit(payment, name)
 	if err != nil {
-		log.Printf("Can't check payment message: %s, err: %v", payment.Message, err)
+		log.Printf("Can't store payment to ledger: %v, %v", payment, err)
+		cw.messageService.SendMessage(fmt.Sprintf("Can't store payment to ledger: %v, %v", payment, err), "")
+		return
 	}
-	if resent {
-		payment.AccountNumber = payment.Message[3:]
+	err = cw.db.MarkPaymentProcessed(payment.Order, matchedBy)
+	if err != nil {
+		log.Printf("Can't mark payment as processed: %v, %v", payment, err)
+		cw.messageService.SendMessage(fmt.Sprintf("Can't mark payment as processed: %v, %v", payment, err), "")
+		return
+	}
+
+	log.Printf("Added %d to %s(%s), account %s, order: %d, matched by: %s", payment.Amount, payment.Name, name, payment.AccountNumber, payment.Order, matchedBy)
+	if name == google.HOSTS {
+		log.Printf("Payment not matched and added to hosts %v", payment)
 	}
 
+	cw.messageService.SendMessage(
+		fmt.Sprintf(
+			"New payment from: %s(%s), account: %s, amount: %d, order: %d, matched by: %s",
+			payment.Name,
+			name,
+			payment.AccountNumber,
+			payment.Amount,
+			payment.Order,
+			matchedBy),
+		"")
+}
+
+// matchPayment finds the player who sent the payment, it tries the counter
+// account and the variable symbol, then player names in the payment name and
+// message. The account wins when it disagrees with the variable symbol, as
+// the symbol may be copied from somebody else's QR. It returns the name and
+// the rule which matched.
+func (cw *CronWorker) matchPayment(payment *bank.Payment, userNames []string) (string, string) {
+	symbolName := ""
+	if payment.VariableSymbol != "" {
+		player, err := cw.db.GetPlayerByVariableSymbol(payment.VariableSymbol)
+		if err != nil {
+			log.Printf("Can't get player for variable symbol: %s, err: %v", payment.VariableSymbol, err)
+		} else {
+			symbolName = player.Name.String
+		}
+	}
+
+	matchedBy := MATCH_ACCOUNT
+	// payments resent from other account carry the original account in message
+	if resentRegexp.MatchString(payment.Message) {
+		payment.AccountNumber = payment.Message[3:]
+		matchedBy = MATCH_RESENT
+	}
 	userName, err := cw.db.GetName(payment.AccountNumber)
 	if err != nil {
 		log.Printf("Can't get user name for account: %s, err: %v", payment.AccountNumber, err)
-		userName = google.HOSTS
-	}
-
-	for i, name := range userNames {
-		if name == userName {
-			cellAddress := fmt.Sprintf("Sheet1!%s2", google.ToColumnIndex(i))
-			v, err := cw.sheetOperator.Get(cellAddress, google.VRO_FORMULA, false)
-			if err != nil {
-				log.Printf("Can't get amount cell for payment: %v, %v", payment, err)
-			}
-
-			v[0] = fmt.Sprintf("%s+%d", v[0], payment.Amount)
-			newValue := []interface{}{v[0]}
-			err = cw.sheetOperator.Write(cellAddress, newValue)
-			if err != nil {
-				log.Printf("Can't store new amount cell for payment: %v, %v", payment, err)
-				cw.messageService.SendMessage(fmt.Sprintf("Can't store new amount cell for payment: %v, %v", payment, err), "")
-				continue
-			}
-			err = cw.db.MarkPaymentProcessed(payment.Order)
-			if err != nil {
-				log.Printf("Can't mark payment as processed: %v, %v", payment, err)
-				cw.messageService.SendMessage(fmt.Sprintf("Can't mark payment as processed: %v, %v", payment, err), "")
-				continue
-			}
-
-			log.Printf("Added %d to %s(%s), account %s, order: %d, resent: %t", payment.Amount, payment.Name, name, payment.AccountNumber, payment.Order, resent)
-			if name == google.HOSTS {
-				log.Printf("Payment not matched and added to hosts %v", payment)
-			}
-
-			cw.messageService.SendMessage(
-				fmt.Sprintf(
-					"New payment from: %s(%s), account: %s, amount: %d, order: %d, resent: %t",
-					payment.Name,
-					name,
-					payment.AccountNumber,
-					payment.Amount,
-					payment.Order,
-					resent),
-				"")
-			break
+	} else if userName != "" {
+		if symbolName != "" && symbolName != userName {
+			log.Printf("Variable symbol %s of %s doesn't match account %s of %s, using the account", payment.VariableSymbol, symbolName, payment.AccountNumber, userName)
+		}
+		return userName, matchedBy
+	}
+	if symbolName != "" {
+		return symbolName, MATCH_VARIABLE_SYMBOL
+	}
+
+	if userName := fuzzyMatch(payment, userNames); userName != "" {
+		return userName, MATCH_FUZZY
+	}
+	return google.HOSTS, MATCH_NONE
+}
+
+// fuzzyMatch returns user name most similar to the payment name or contained
+// in the payment message, word order is ignored as banks often put surname
+// first
+func fuzzyMatch(payment *bank.Payment, userNames []string) string {
+	lev := metrics.NewLevenshtein()
+	paymentName := sortedWords(payment.Name)
+	messageWords := strings.Fields(utils.Normalize(payment.Message))
+	bestName, bestSimilarity := "", FUZZY_MATCH_THRESHOLD
+	for _, name := range userNames {
+		if name == "" || name == google.HOSTS {
+			continue
+		}
+		words := strings.Fields(utils.Normalize(name))
+		if len(words) > 1 && containsAll(messageWords, words) {
+			return name
+		}
+		if paymentName == "" {
+			continue
+		}
+		if similarity := strutil.Similarity(paymentName, sortedWords(name), lev); similarity > bestSimilarity {
+			bestName, bestSimilarity = name, similarity
+		}
+	}
+	return bestName
+}
+
+func sortedWords(value string) string {
+	words := strings.Fields(utils.Normalize(value))
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+func containsAll(words, required []string) bool {
+	for _, word := range required {
+		if !slices.Contains(words, word) {
+			return false
 		}
 	}
+	return true
 }
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	LEDGER_CREDIT     = "credit"
+	LEDGER_DEBIT      = "debit"
+	LEDGER_ADJUSTMENT = "adjustment"
+
+	// LEDGER_PLAYER_ID selects ID of the player named $1, entries of names
+	// without player, e.g. hosts, have no player ID
+	LEDGER_PLAYER_ID = `(SELECT id FROM players WHERE name = $1 LIMIT 1)`
+	// LEDGER_NAME is the current name of the player of the entry
+	LEDGER_NAME = `COALESCE(p.name, e.name)`
+)
+
+// LedgerEntry changes balance of the player, credits are positive amounts,
+// debits negative, adjustments can be both
+type LedgerEntry struct {
+	Id           sql.NullInt64  `db:"id" json:"id"`
+	PlayerId     sql.NullInt64  `db:"player_id" json:"player_id"`
+	Name         sql.NullString `db:"name" json:"name"`
+	EntryType    sql.NullString `db:"entry_type" json:"entry_type"`
+	Amount       sql.NullInt64  `db:"amount" json:"amount"`
+	Description  sql.NullString `db:"description" json:"description"`
+	PaymentOrder sql.NullInt64  `db:"payment_order" json:"payment_order"`
+	CreatedBy    sql.NullString `db:"created_by" json:"created_by"`
+	CreatedAt    sql.NullTime   `db:"created_at" json:"created_at"`
+}
+
+// Balance of the player, Credits are the bank payments only, Debits the
+// charges and Balance includes adjustments
+type Balance struct {
+	Name    sql.NullString `db:"name" json:"name"`
+	Credits sql.NullInt64  `db:"credits" json:"credits"`
+	Debits  sql.NullInt64  `db:"debits" json:"debits"`
+	Balance sql.NullInt64  `db:"balance" json:"balance"`
+}
+
+// AddCredit stores the payment, each payment is credited only once
+func (c *Client) AddCredit(name, description string, amount, paymentOrder int, createdAt time.Time) error {
+	_, err := c.db.Exec(`INSERT INTO ledger_entries (player_id, name, entry_type, amount, description, payment_order, created_by, created_at) SELECT `+LEDGER_PLAYER_ID+`, $1, $2, $3, $4, $5, 'bank', $6 WHERE NOT EXISTS (SELECT 1 FROM ledger_entries WHERE payment_order = $5)`, name, LEDGER_CREDIT, amount, description, paymentOrder, createdAt)
+	return err
+}
+
+// AddDebits charges all the players the amount in one transaction
+func (c *Client) AddDebits(names []string, amount int, description, createdBy string) error {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range names {
+		if _, err := tx.Exec(`INSERT INTO ledger_entries (player_id, name, entry_type, amount, description, created_by, created_at) VALUES (`+LEDGER_PLAYER_ID+`, $1, $2, $3, $4, $5, $6)`, name, LEDGER_DEBIT, -amount, description, createdBy, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *Client) AddAdjustment(name string, amount int, description, createdBy string) error {
+	_, err := c.db.Exec(`INSERT INTO ledger_entries (player_id, name, entry_type, amount, description, created_by, created_at) VALUES (`+LEDGER_PLAYER_ID+`, $1, $2, $3, $4, $5, $6)`, name, LEDGER_ADJUSTMENT, amount, description, createdBy, time.Now())
+	return err
+}
+
+// IsLedgerInitialized tells whether the opening balances were imported
+func (c *Client) IsLedgerInitialized() (bool, error) {
+	var initialized bool
+	if err := c.db.Get(&initialized, `SELECT EXISTS (SELECT 1 FROM ledger_initialization)`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return false, err
+	}
+	return initialized, nil
+}
+
+// InitLedger stores the opening balances and marks the ledger initialized in
+// one transaction, it fails when the ledger was already initialized
+func (c *Client) InitLedger(balances map[string]int, description, createdBy string) error {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	// the single row table can't be initialized twice
+	if _, err := tx.Exec(`INSERT INTO ledger_initialization (initialized_at, initialized_by) VALUES ($1, $2)`, now, createdBy); err != nil {
+		tx.Rollback()
+		log.Printf("DB query error %v\n", err)
+		return err
+	}
+	for name, balance := range balances {
+		if _, err := tx.Exec(`INSERT INTO ledger_entries (player_id, name, entry_type, amount, description, created_by, created_at) VALUES (`+LEDGER_PLAYER_ID+`, $1, $2, $3, $4, $5, $6)`, name, LEDGER_ADJUSTMENT, balance, description, createdBy, now); err != nil {
+			tx.Rollback()
+			log.Printf("DB query error %v\n", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *Client) GetBalances() ([]Balance, error) {
+	var balances []Balance
+	if err := c.db.Select(&balances, `SELECT `+LEDGER_NAME+` AS name, COALESCE(SUM(e.amount) FILTER (WHERE e.entry_type = $1), 0) AS credits, COALESCE(SUM(e.amount) FILTER (WHERE e.entry_type = $2), 0) AS debits, SUM(e.amount) AS balance FROM ledger_entries AS e LEFT JOIN players AS p ON p.id = e.player_id GROUP BY `+LEDGER_NAME+` ORDER BY `+LEDGER_NAME, LEDGER_CREDIT, LEDGER_DEBIT); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return balances, err
+	}
+	return balances, nil
+}
+
+// GetLedgerEntries returns entries of the player created in [from, to), all
+// players when name is empty
+func (c *Client) GetLedgerEntries(name string, from, to time.Time) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	if err := c.db.Select(&entries, `SELECT e.id, e.player_id, `+LEDGER_NAME+` AS name, e.entry_type, e.amount, e.description, e.payment_order, e.created_by, e.created_at FROM ledger_entries AS e LEFT JOIN players AS p ON p.id = e.player_id WHERE ($1 = '' OR `+LEDGER_NAME+` = $1) AND e.created_at >= $2 AND e.created_at < $3 ORDER BY e.created_at, e.id`, name, from, to); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return entries, err
+	}
+	return entries, nil
+}
+
+func (c *Client) GetPlayers() ([]Player, error) {
+	var players []Player
+	if err := c.db.Select(&players, `SELECT * FROM players ORDER BY name`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return players, err
+	}
+	return players, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"path"
+	"time"
+)
+
+// MIGRATIONS_LOCK_ID is the advisory lock held while a migration is applied,
+// so instances starting together don't apply it twice
+const MIGRATIONS_LOCK_ID = 8213
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate applies the migrations which weren't applied yet in order of their
+// file names, each one in its own transaction
+func (c *Client) Migrate() error {
+	if _, err := c.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (name TEXT PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL)`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return err
+	}
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.migrate(file); err != nil {
+			log.Printf("Can't apply migration %s: %v\n", file, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) migrate(file string) error {
+	query, err := migrations.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	name := path.Base(file)
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, MIGRATIONS_LOCK_ID); err != nil {
+		return err
+	}
+	var applied bool
+	if err := tx.Get(&applied, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE name = $1)`, name); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, err := tx.Exec(string(query)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (name, applied_at) VALUES ($1, $2)`, name, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Migration %s applied\n", name)
+	return nil
+}
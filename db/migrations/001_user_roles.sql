@@ -0,0 +1,5 @@
+-- roles of GroupMe users, users without a row are members
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id TEXT PRIMARY KEY,
+    role    TEXT NOT NULL CHECK (role IN ('admin', 'treasurer', 'captain', 'member'))
+);
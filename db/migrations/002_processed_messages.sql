@@ -0,0 +1,9 @@
+-- GroupMe messages already processed, old rows are purged by the bot
+CREATE TABLE IF NOT EXISTS processed_messages (
+    message_id   TEXT PRIMARY KEY,
+    command      TEXT,
+    outcome      TEXT,
+    processed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS processed_messages_processed_at_idx ON processed_messages (processed_at);
@@ -0,0 +1,7 @@
+-- groups the bot replies to, commands is a comma separated list of commands
+-- allowed in the group, empty allows all
+CREATE TABLE IF NOT EXISTS groupme_groups (
+    group_id TEXT PRIMARY KEY,
+    bot_id   TEXT,
+    commands TEXT
+);
@@ -0,0 +1,7 @@
+-- GroupMe users linked to players by LINK_PLAYER
+CREATE TABLE IF NOT EXISTS groupme_users (
+    user_id   TEXT PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES players (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS groupme_users_player_id_idx ON groupme_users (player_id);
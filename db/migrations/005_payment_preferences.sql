@@ -0,0 +1,5 @@
+-- payment QR format preferred by GroupMe users, SPD when missing
+CREATE TABLE IF NOT EXISTS payment_preferences (
+    user_id TEXT PRIMARY KEY,
+    format  TEXT NOT NULL CHECK (format IN ('spd', 'epc'))
+);
@@ -0,0 +1,5 @@
+-- bank transaction ID of payments imported from statements, imported payments
+-- without ČSOB accounting order have negative accounted_order
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transaction_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_transaction_id_idx ON payments (transaction_id) WHERE transaction_id IS NOT NULL;
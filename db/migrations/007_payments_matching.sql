@@ -0,0 +1,5 @@
+-- payment details used to match the payer, matched_by is the rule the payer
+-- was found by
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS message TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS variable_symbol TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS matched_by TEXT;
@@ -0,0 +1,22 @@
+-- player balances are sums of the ledger entries, bank credits are stored
+-- once per payment
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id            SERIAL PRIMARY KEY,
+    name          TEXT NOT NULL,
+    entry_type    TEXT NOT NULL CHECK (entry_type IN ('credit', 'debit', 'adjustment')),
+    amount        INTEGER NOT NULL,
+    description   TEXT,
+    payment_order BIGINT,
+    created_by    TEXT,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_payment_order_idx ON ledger_entries (payment_order) WHERE payment_order IS NOT NULL;
+CREATE INDEX IF NOT EXISTS ledger_entries_name_created_at_idx ON ledger_entries (name, created_at);
+
+-- single row written by LEDGER_INIT, the ledger refuses writes until then
+CREATE TABLE IF NOT EXISTS ledger_initialization (
+    id             BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    initialized_at TIMESTAMPTZ NOT NULL,
+    initialized_by TEXT
+);
@@ -0,0 +1,11 @@
+-- players who turned debt reminders off
+CREATE TABLE IF NOT EXISTS reminder_opt_outs (
+    name TEXT PRIMARY KEY
+);
+
+-- number of weeks in a row the player has been reminded of the debt
+CREATE TABLE IF NOT EXISTS debt_reminders (
+    name        TEXT PRIMARY KEY,
+    weeks       INTEGER NOT NULL,
+    reminded_at TIMESTAMPTZ NOT NULL
+);
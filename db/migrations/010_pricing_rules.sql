@@ -0,0 +1,14 @@
+-- pricing category of the player, goalies are recognized by post when empty
+ALTER TABLE players ADD COLUMN IF NOT EXISTS category TEXT CHECK (category IN ('goalie', 'junior', 'guest'));
+
+-- empty event_type, location, weekday or category matches any event, rules
+-- without category set the base price
+CREATE TABLE IF NOT EXISTS pricing_rules (
+    id         SERIAL PRIMARY KEY,
+    event_type TEXT CHECK (event_type IN ('game', 'practice')),
+    location   TEXT,
+    weekday    INTEGER CHECK (weekday BETWEEN 0 AND 6),
+    category   TEXT CHECK (category IN ('goalie', 'junior', 'guest')),
+    mode       TEXT NOT NULL CHECK (mode IN ('fixed', 'split', 'discount', 'surcharge')),
+    amount     INTEGER NOT NULL CHECK (amount >= 0)
+);
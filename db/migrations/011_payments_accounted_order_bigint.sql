@@ -0,0 +1,2 @@
+-- Fio movement IDs used as accounting order don't fit into INTEGER
+ALTER TABLE payments ALTER COLUMN accounted_order TYPE BIGINT;
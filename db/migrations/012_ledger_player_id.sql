@@ -0,0 +1,7 @@
+-- entries of players are kept by player ID, so renamed players keep their
+-- balance, name is kept for hosts and names without player
+ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS player_id INTEGER REFERENCES players (id);
+
+UPDATE ledger_entries AS e SET player_id = p.id FROM players AS p WHERE e.player_id IS NULL AND p.name = e.name;
+
+CREATE INDEX IF NOT EXISTS ledger_entries_player_id_created_at_idx ON ledger_entries (player_id, created_at);
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsNumbered(t *testing.T) {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, file := range files {
+		if prefix := fmt.Sprintf("%03d_", i+1); !strings.HasPrefix(path.Base(file), prefix) {
+			t.Errorf("migration %s should start with %s", file, prefix)
+		}
+	}
+}
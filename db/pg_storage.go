@@ -3,7 +3,10 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,6 +17,24 @@ const (
 	GOALIE  = "goalie"
 	DEFENSE = "defense"
 	FORWARD = "forward"
+
+	ROLE_ADMIN     = "admin"
+	ROLE_TREASURER = "treasurer"
+	ROLE_CAPTAIN   = "captain"
+	ROLE_MEMBER    = "member"
+
+	MESSAGE_PROCESSING = "processing"
+	// MESSAGE_CLAIM_TIMEOUT - messages still processing after the timeout
+	// were claimed by a crashed or killed instance and can be claimed again
+	MESSAGE_CLAIM_TIMEOUT = 15 * time.Minute
+
+	PAYMENT_FORMAT_SPD = "spd"
+	PAYMENT_FORMAT_EPC = "epc"
+
+	// variable symbols of players are the prefix, zero padded player ID and
+	// a check digit, so they don't collide with short symbols typed by hand
+	VARIABLE_SYMBOL_PREFIX    = "77"
+	VARIABLE_SYMBOL_ID_LENGTH = 7
 )
 
 func NewClient(dbURL string) *Client {
@@ -57,6 +78,8 @@ type Player struct {
 	Name   sql.NullString `db:"name" json:"name"`
 	Number sql.NullInt64  `db:"number" json:"number"`
 	Post   sql.NullString `db:"post" json:"post"`
+	// Category is used for pricing, e.g. junior
+	Category sql.NullString `db:"category" json:"category"`
 }
 
 type BankAccount struct {
@@ -66,10 +89,25 @@ type BankAccount struct {
 }
 
 type Payment struct {
-	Account sql.NullString `db:"account" json:"account"`
-	Name    sql.NullString `db:"name" json:"name"`
-	Amount  sql.NullInt64  `db:"amount" json:"amount"`
-	Order   sql.NullInt64  `db:"accounted_order" json:"accounted_order"`
+	Account        sql.NullString `db:"account" json:"account"`
+	Name           sql.NullString `db:"name" json:"name"`
+	Message        sql.NullString `db:"message" json:"message"`
+	VariableSymbol sql.NullString `db:"variable_symbol" json:"variable_symbol"`
+	Amount         sql.NullInt64  `db:"amount" json:"amount"`
+	Order          sql.NullInt64  `db:"accounted_order" json:"accounted_order"`
+}
+
+type Group struct {
+	GroupID  sql.NullString `db:"group_id" json:"group_id"`
+	BotID    sql.NullString `db:"bot_id" json:"bot_id"`
+	Commands sql.NullString `db:"commands" json:"commands"`
+}
+
+type ProcessedMessage struct {
+	MessageID   sql.NullString `db:"message_id" json:"message_id"`
+	Command     sql.NullString `db:"command" json:"command"`
+	Outcome     sql.NullString `db:"outcome" json:"outcome"`
+	ProcessedAt sql.NullTime   `db:"processed_at" json:"processed_at"`
 }
 
 func (c *Client) GetGroupmeAccount(userID string) (string, error) {
@@ -113,9 +151,59 @@ func (c *Client) GetPlayerByName(name string) (Player, error) {
 	return player, nil
 }
 
+// VariableSymbol returns stable variable symbol of the player
+func VariableSymbol(playerID int64) string {
+	digits := fmt.Sprintf("%s%0*d", VARIABLE_SYMBOL_PREFIX, VARIABLE_SYMBOL_ID_LENGTH, playerID)
+	return digits + strconv.Itoa(checkDigit(digits))
+}
+
+// PlayerIDFromVariableSymbol validates variable symbol created by
+// VariableSymbol and returns the player ID
+func PlayerIDFromVariableSymbol(variableSymbol string) (int64, error) {
+	vs := strings.TrimLeft(variableSymbol, "0")
+	if len(vs) != len(VARIABLE_SYMBOL_PREFIX)+VARIABLE_SYMBOL_ID_LENGTH+1 || !strings.HasPrefix(vs, VARIABLE_SYMBOL_PREFIX) {
+		return 0, fmt.Errorf("not a player variable symbol: %s", variableSymbol)
+	}
+	digits := vs[:len(vs)-1]
+	id, err := strconv.ParseInt(digits[len(VARIABLE_SYMBOL_PREFIX):], 10, 64)
+	if err != nil || id <= 0 || vs[len(vs)-1:] != strconv.Itoa(checkDigit(digits)) {
+		return 0, fmt.Errorf("invalid variable symbol: %s", variableSymbol)
+	}
+	return id, nil
+}
+
+// checkDigit computes Luhn check digit of the digits
+func checkDigit(digits string) int {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+func (c *Client) GetPlayerByVariableSymbol(variableSymbol string) (Player, error) {
+	var player Player
+	id, err := PlayerIDFromVariableSymbol(variableSymbol)
+	if err != nil {
+		return player, err
+	}
+	if err := c.db.Get(&player, `SELECT * FROM players WHERE id = $1`, id); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return player, err
+	}
+	return player, nil
+}
+
 func (c *Client) GetLastPaymentOrder() (int, error) {
 	var lastOrder int
-	if err := c.db.Get(&lastOrder, `SELECT accounted_order FROM payments ORDER BY accounted_order DESC LIMIT 1`); err != nil {
+	if err := c.db.Get(&lastOrder, `SELECT accounted_order FROM payments WHERE accounted_order > 0 ORDER BY accounted_order DESC LIMIT 1`); err != nil {
 		log.Printf("DB query error %v\n", err)
 		return 0, err
 	}
@@ -126,19 +214,55 @@ func (c *Client) GetLastPaymentOrder() (int, error) {
 	return lastOrder, nil
 }
 
-func (c *Client) StorePayment(name, account string, amount, order int, timestamp time.Time) error {
-	_, err := c.db.Exec(`INSERT INTO payments (name, account, amount, accounted_order, accounted_at) VALUES ($1, $2, $3, $4, $5)`, name, account, amount, order, timestamp)
+func (c *Client) StorePayment(name, account, message, variableSymbol, transactionID string, amount, order int, timestamp time.Time) error {
+	_, err := c.db.Exec(`INSERT INTO payments (name, account, message, variable_symbol, transaction_id, amount, accounted_order, accounted_at) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8)`, name, account, message, variableSymbol, transactionID, amount, order, timestamp)
 	return err
 }
 
-func (c *Client) MarkPaymentProcessed(order int) error {
-	_, err := c.db.Exec(`UPDATE payments SET processed_at = $2 WHERE accounted_order = $1`, order, time.Now())
+// PaymentExists checks whether payment with the accounting order or the bank
+// transaction ID is already stored
+func (c *Client) PaymentExists(order int, transactionID string) (bool, error) {
+	var exists bool
+	err := c.db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM payments WHERE (accounted_order = $1 AND $1 <> 0) OR (transaction_id IS NOT NULL AND transaction_id = NULLIF($2, '')))`, order, transactionID)
+	return exists, err
+}
+
+// CountMatchingPayments counts stored payments without the bank transaction ID
+// with the same account, amount and variable symbol accounted within a day of
+// timestamp. It finds payments stored from the ČSOB API when the same period
+// is imported from a statement, the account prefix is ignored as the API
+// doesn't provide it.
+func (c *Client) CountMatchingPayments(account, variableSymbol string, amount int, timestamp time.Time) (int, error) {
+	var count int
+	err := c.db.Get(&count, `SELECT COUNT(*) FROM payments WHERE transaction_id IS NULL AND regexp_replace(account, '^[0-9]+-', '') = regexp_replace($1, '^[0-9]+-', '') AND COALESCE(variable_symbol, '') = $2 AND amount = $3 AND accounted_at BETWEEN $4::timestamptz - interval '1 day' AND $4::timestamptz + interval '1 day'`, account, variableSymbol, amount, timestamp)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return count, err
+}
+
+// NextImportedPaymentOrder returns order for payments imported from statements
+// without the ČSOB accounting order, they are numbered down from -1 so they
+// don't affect the last checked order
+func (c *Client) NextImportedPaymentOrder() (int, error) {
+	var order int
+	if err := c.db.Get(&order, `SELECT LEAST(COALESCE(MIN(accounted_order), 0), 0) - 1 FROM payments`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return 0, err
+	}
+	return order, nil
+}
+
+// MarkPaymentProcessed stores when the payment was processed, matchedBy is
+// the rule the payer was found by
+func (c *Client) MarkPaymentProcessed(order int, matchedBy string) error {
+	_, err := c.db.Exec(`UPDATE payments SET processed_at = $2, matched_by = $3 WHERE accounted_order = $1`, order, time.Now(), matchedBy)
 	return err
 }
 
 func (c *Client) GetUnprocessedPayments() ([]Payment, error) {
 	var payments []Payment
-	if err := c.db.Select(&payments, `SELECT name, account, amount, accounted_order FROM payments WHERE accounted_at >= timestamp '2023-09-01 00:00:00' AND processed_at IS NULL ORDER BY accounted_order ASC`); err != nil {
+	if err := c.db.Select(&payments, `SELECT name, account, message, variable_symbol, amount, accounted_order FROM payments WHERE accounted_at >= timestamp '2023-09-01 00:00:00' AND processed_at IS NULL ORDER BY accounted_order ASC`); err != nil {
 		log.Printf("DB query error %v\n", err)
 		return payments, err
 	}
@@ -161,3 +285,141 @@ func (c *Client) IsException(date, time string) (bool, error) {
 	}
 	return count > 0, nil
 }
+
+func (c *Client) GetRole(userID string) (string, error) {
+	var role string
+	if err := c.db.Get(&role, `SELECT role FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ROLE_MEMBER, nil
+		}
+		log.Printf("DB query error %v\n", err)
+		return "", err
+	}
+	return role, nil
+}
+
+func (c *Client) SetRole(userID, role string) error {
+	_, err := c.db.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET role = $2`, userID, role)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
+
+func (c *Client) RemoveRole(userID string) error {
+	_, err := c.db.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
+
+// ClaimMessage records the message as being processed, it returns false when
+// the message was already claimed before, unless the claim is still
+// processing after MESSAGE_CLAIM_TIMEOUT
+func (c *Client) ClaimMessage(messageID, command string) (bool, error) {
+	now := time.Now()
+	result, err := c.db.Exec(`INSERT INTO processed_messages (message_id, command, outcome, processed_at) VALUES ($1, $2, $3, $4) ON CONFLICT (message_id) DO UPDATE SET command = $2, processed_at = $4 WHERE processed_messages.outcome = $3 AND processed_messages.processed_at < $5`, messageID, command, MESSAGE_PROCESSING, now, now.Add(-MESSAGE_CLAIM_TIMEOUT))
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+func (c *Client) GetProcessedMessage(messageID string) (ProcessedMessage, error) {
+	var message ProcessedMessage
+	if err := c.db.Get(&message, `SELECT message_id, command, outcome, processed_at FROM processed_messages WHERE message_id = $1`, messageID); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return message, err
+	}
+	return message, nil
+}
+
+func (c *Client) StoreMessageOutcome(messageID, outcome string) error {
+	_, err := c.db.Exec(`UPDATE processed_messages SET outcome = $2 WHERE message_id = $1`, messageID, outcome)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
+
+func (c *Client) DeleteProcessedMessages(before time.Time) (int64, error) {
+	result, err := c.db.Exec(`DELETE FROM processed_messages WHERE processed_at < $1`, before)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c *Client) GetGroups() ([]Group, error) {
+	var groups []Group
+	if err := c.db.Select(&groups, `SELECT group_id, bot_id, commands FROM groupme_groups`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return groups, err
+	}
+	return groups, nil
+}
+
+func (c *Client) SetGroupmeUser(userID string, playerID int64) error {
+	_, err := c.db.Exec(`INSERT INTO groupme_users (user_id, player_id) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET player_id = $2`, userID, playerID)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
+
+// GetGroupmeUserID returns GroupMe user ID of the player with given name or
+// nickname, empty string if the player isn't linked to any GroupMe user
+func (c *Client) GetGroupmeUserID(name string) (string, error) {
+	var userID string
+	if err := c.db.Get(&userID, `SELECT g.user_id FROM groupme_users AS g JOIN players AS p ON p.id = g.player_id LEFT JOIN nicknames AS n ON p.id = n.player_id WHERE p.name = $1 OR n.nickname = $1 LIMIT 1`, name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		log.Printf("DB query error %v\n", err)
+		return "", err
+	}
+	return userID, nil
+}
+
+// GetPlayerByGroupmeUser returns player linked to the GroupMe user, the
+// player is not valid when the user isn't linked
+func (c *Client) GetPlayerByGroupmeUser(userID string) (Player, error) {
+	var player Player
+	if err := c.db.Get(&player, `SELECT p.* FROM players AS p JOIN groupme_users AS g ON p.id = g.player_id WHERE g.user_id = $1`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return player, nil
+		}
+		log.Printf("DB query error %v\n", err)
+		return player, err
+	}
+	return player, nil
+}
+
+// GetPaymentFormat returns QR payment format preferred by the user, SPD by default
+func (c *Client) GetPaymentFormat(userID string) (string, error) {
+	var format string
+	if err := c.db.Get(&format, `SELECT format FROM payment_preferences WHERE user_id = $1`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PAYMENT_FORMAT_SPD, nil
+		}
+		log.Printf("DB query error %v\n", err)
+		return "", err
+	}
+	return format, nil
+}
+
+func (c *Client) SetPaymentFormat(userID, format string) error {
+	_, err := c.db.Exec(`INSERT INTO payment_preferences (user_id, format) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET format = $2`, userID, format)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package database
+
+import "testing"
+
+func TestVariableSymbol(t *testing.T) {
+	tests := []struct {
+		playerID int64
+		want     string
+	}{
+		{1, "7700000016"},
+		{12, "7700000123"},
+		{1234567, "7712345672"},
+	}
+	for _, tt := range tests {
+		got := VariableSymbol(tt.playerID)
+		if got != tt.want {
+			t.Errorf("VariableSymbol(%d) = %s, want %s", tt.playerID, got, tt.want)
+		}
+		id, err := PlayerIDFromVariableSymbol(got)
+		if err != nil || id != tt.playerID {
+			t.Errorf("PlayerIDFromVariableSymbol(%s) = %d, %v, want %d", got, id, err, tt.playerID)
+		}
+	}
+}
+
+func TestPlayerIDFromVariableSymbolErrors(t *testing.T) {
+	for _, vs := range []string{
+		"",
+		"1012",
+		// the old 1000 + player ID format
+		"1001",
+		"7700000017",
+		"7700000000",
+		"6600000016",
+		"770000001",
+		"77000000160",
+		"77000a0016",
+	} {
+		if id, err := PlayerIDFromVariableSymbol(vs); err == nil {
+			t.Errorf("PlayerIDFromVariableSymbol(%q) = %d, expected error", vs, id)
+		}
+	}
+}
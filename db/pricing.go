@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+const (
+	// base price modes
+	PRICE_FIXED = "fixed"
+	PRICE_SPLIT = "split"
+	// player category price modes, applied to the base price
+	PRICE_DISCOUNT  = "discount"
+	PRICE_SURCHARGE = "surcharge"
+
+	CATEGORY_JUNIOR = "junior"
+	CATEGORY_GUEST  = "guest"
+
+	EVENT_GAME     = "game"
+	EVENT_PRACTICE = "practice"
+)
+
+// PricingRule sets price of the events it matches, empty event type, location,
+// weekday or category matches any. Rules without category set the base price,
+// for split rules it's the rink cost split among the paying attendees.
+type PricingRule struct {
+	Id        sql.NullInt64  `db:"id" json:"id"`
+	EventType sql.NullString `db:"event_type" json:"event_type"`
+	Location  sql.NullString `db:"location" json:"location"`
+	Weekday   sql.NullInt64  `db:"weekday" json:"weekday"`
+	Category  sql.NullString `db:"category" json:"category"`
+	Mode      sql.NullString `db:"mode" json:"mode"`
+	Amount    sql.NullInt64  `db:"amount" json:"amount"`
+}
+
+func (c *Client) GetPricingRules() ([]PricingRule, error) {
+	var rules []PricingRule
+	if err := c.db.Select(&rules, `SELECT * FROM pricing_rules ORDER BY id`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return rules, err
+	}
+	return rules, nil
+}
+
+func (c *Client) AddPricingRule(rule PricingRule) (int64, error) {
+	var id int64
+	if err := c.db.Get(&id, `INSERT INTO pricing_rules (event_type, location, weekday, category, mode, amount) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`, rule.EventType, rule.Location, rule.Weekday, rule.Category, rule.Mode, rule.Amount); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (c *Client) DeletePricingRule(id int64) (bool, error) {
+	result, err := c.db.Exec(`DELETE FROM pricing_rules WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
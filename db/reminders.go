@@ -0,0 +1,61 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// GetReminderOptOuts returns names of the players who don't want debt
+// reminders
+func (c *Client) GetReminderOptOuts() ([]string, error) {
+	var names []string
+	if err := c.db.Select(&names, `SELECT name FROM reminder_opt_outs`); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return names, err
+	}
+	return names, nil
+}
+
+func (c *Client) SetReminderOptOut(name string, optOut bool) error {
+	var err error
+	if optOut {
+		_, err = c.db.Exec(`INSERT INTO reminder_opt_outs (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name)
+	} else {
+		_, err = c.db.Exec(`DELETE FROM reminder_opt_outs WHERE name = $1`, name)
+	}
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
+
+// GetDebtWeeks returns number of weeks the player has been reminded in a row,
+// zero when the player wasn't reminded
+func (c *Client) GetDebtWeeks(name string) (int, error) {
+	var weeks int
+	if err := c.db.Get(&weeks, `SELECT COALESCE((SELECT weeks FROM debt_reminders WHERE name = $1), 0)`, name); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return 0, err
+	}
+	return weeks, nil
+}
+
+// IncrementDebtWeeks records another reminded week of the player and returns
+// number of weeks the player has been reminded in a row
+func (c *Client) IncrementDebtWeeks(name string, remindedAt time.Time) (int, error) {
+	var weeks int
+	if err := c.db.Get(&weeks, `INSERT INTO debt_reminders (name, weeks, reminded_at) VALUES ($1, 1, $2) ON CONFLICT (name) DO UPDATE SET weeks = debt_reminders.weeks + 1, reminded_at = $2 RETURNING weeks`, name, remindedAt); err != nil {
+		log.Printf("DB query error %v\n", err)
+		return 0, err
+	}
+	return weeks, nil
+}
+
+// ResetDebtWeeks forgets the reminders of the player who paid the debt
+func (c *Client) ResetDebtWeeks(name string) error {
+	_, err := c.db.Exec(`DELETE FROM debt_reminders WHERE name = $1`, name)
+	if err != nil {
+		log.Printf("DB query error %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/google"
+	"github.com/vlcak/groupme_qr_bot/groupme"
+)
+
+const DEBT_REMINDER_MESSAGE = "dluh"
+
+// DebtReminderConfig configures weekly reminders of players in debt
+type DebtReminderConfig struct {
+	// Account the debts are paid to
+	Account string
+	// Threshold - players with lower balance are reminded
+	Threshold int
+	// EscalationWeeks - after this many reminders in a row the debtor is
+	// mentioned in the group even when the reminder is sent privately
+	EscalationWeeks int
+	// EURRate is CZK amount of one EUR used for EPC payments
+	EURRate float64
+}
+
+// SendDebtReminders reminds players with balance below the threshold to pay
+// the debt, linked players get the QR privately when direct messages are
+// enabled, the rest in the group
+func (cw *CronWorker) SendDebtReminders() {
+	log.Printf("Sending debt reminders")
+	balances, err := cw.ledger.Balances()
+	if err != nil {
+		log.Printf("Can't get balances: %v", err)
+		return
+	}
+	optOuts, err := cw.db.GetReminderOptOuts()
+	if err != nil {
+		log.Printf("Can't get reminder opt-outs: %v", err)
+		return
+	}
+	optedOut := map[string]bool{}
+	for _, name := range optOuts {
+		optedOut[name] = true
+	}
+
+	names := make([]string, 0, len(balances))
+	for name := range balances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	var escalated []string
+	var escalatedWeeks []int
+	for _, name := range names {
+		debt := -balances[name]
+		if name == google.HOSTS {
+			continue
+		}
+		if balances[name] >= cw.reminders.Threshold || debt <= 0 {
+			if err := cw.db.ResetDebtWeeks(name); err != nil {
+				log.Printf("Can't reset debt weeks of %s: %v", name, err)
+			}
+			continue
+		}
+		if optedOut[name] {
+			log.Printf("%s opted out of debt reminders, debt: %d", name, debt)
+			continue
+		}
+		weeks, err := cw.db.GetDebtWeeks(name)
+		if err != nil {
+			log.Printf("Can't get debt weeks of %s: %v", name, err)
+			continue
+		}
+		// the week is counted only when the reminder was delivered
+		if err := cw.sendDebtReminder(name, debt, weeks+1); err != nil {
+			log.Printf("Can't send debt reminder to %s: %v", name, err)
+			continue
+		}
+		weeks, err = cw.db.IncrementDebtWeeks(name, now)
+		if err != nil {
+			log.Printf("Can't store debt weeks of %s: %v", name, err)
+			continue
+		}
+		if cw.reminders.EscalationWeeks > 0 && weeks >= cw.reminders.EscalationWeeks {
+			escalated = append(escalated, name)
+			escalatedWeeks = append(escalatedWeeks, weeks)
+		}
+	}
+	if len(escalated) == 0 {
+		return
+	}
+
+	message := "Dlouhodobě nezaplaceno:\n"
+	var mentions []groupme.Mention
+	for i, name := range escalated {
+		message += fmt.Sprintf("%s(%d, weeks: %d)\n", mentionPlayer(cw.db, name, &mentions), -balances[name], escalatedWeeks[i])
+	}
+	if err := cw.messageService.SendMessageWithMentions(message, "", mentions); err != nil {
+		log.Printf("Can't send escalated debtors: %v", err)
+	}
+}
+
+// sendDebtReminder sends the payment QR for the debt privately when the
+// player is linked, otherwise to the group. The QR is in the format preferred
+// by the linked user.
+func (cw *CronWorker) sendDebtReminder(name string, debt, weeks int) error {
+	variableSymbol := ""
+	if player, err := cw.db.GetPlayerByName(name); err == nil && player.Id.Valid {
+		variableSymbol = database.VariableSymbol(player.Id.Int64)
+	}
+	userID, err := cw.db.GetGroupmeUserID(name)
+	if err != nil {
+		log.Printf("Can't get GroupMe user of %s: %v", name, err)
+		userID = ""
+	}
+	message, _ := cw.paymentGenerator.SanitizeMessage(fmt.Sprintf("%s %s", DEBT_REMINDER_MESSAGE, name))
+	image, amountDescription, err := paymentQR(cw.db, cw.paymentGenerator, cw.reminders.EURRate, userID, TEAM_NAME, message, variableSymbol, cw.reminders.Account, float64(debt))
+	if err != nil {
+		return err
+	}
+	imageURL, err := cw.imageService.Upload(image)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("Připomínka platby: dluh %s (week %d)", amountDescription, weeks)
+
+	if cw.directMessageService != nil && userID != "" {
+		return cw.directMessageService.SendMessage(userID, text, imageURL)
+	}
+	var mentions []groupme.Mention
+	return cw.messageService.SendMessageWithMentions(fmt.Sprintf("%s: %s", mentionPlayer(cw.db, name, &mentions), text), imageURL, mentions)
+}
@@ -91,7 +91,7 @@ func (ec *EventCreator) CreateEvent(where, date, startTime, capacity, name, opon
 	// parse when
 	locationPrague, err := time.LoadLocation("Europe/Prague")
 	if err != nil {
-		log.Printf("Error:", err)
+		log.Printf("Error: %v", err)
 		return "", err
 	}
 
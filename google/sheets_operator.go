@@ -14,6 +14,7 @@ const (
 
 	IDO_INSERT_ROWS       = "INSERT_ROWS"
 	VIO_USER_ENTERED      = "USER_ENTERED"
+	VIO_RAW               = "RAW"
 	VRO_FORMULA           = "FORMULA"
 	VRO_UNFORMATTED_VALUE = "UNFORMATTED_VALUE"
 	VRO_FORMATTED_VALUE   = "FORMATTED_VALUE"
@@ -40,9 +41,9 @@ type SheetOperator struct {
 
 func ToColumnIndex(index int) string {
 	if index < 26 {
-		return string('A' + index)
+		return string(rune('A' + index))
 	}
-	return fmt.Sprintf("%s%s", string('A'+(index/26)-1), string('A'+(index%26)))
+	return fmt.Sprintf("%s%s", string(rune('A'+(index/26)-1)), string(rune('A'+(index%26))))
 }
 
 func (so *SheetOperator) GetReadOnlyURL() string {
@@ -132,3 +133,22 @@ func (so *SheetOperator) AppendLine(sheetName string, newValues []interface{}) e
 	}
 	return nil
 }
+
+// Replace clears the sheet and writes the rows from the first cell, values
+// are written as they are without parsing formulas
+func (so *SheetOperator) Replace(sheetName string, rows [][]interface{}) error {
+	_, err := so.service.Spreadsheets.Values.Clear(so.spreadsheetId, sheetName, &sheets.ClearValuesRequest{}).Do()
+	if err != nil {
+		log.Printf("Unable to clear sheet: %v", err)
+		return err
+	}
+	rb := &sheets.ValueRange{
+		Values: rows,
+	}
+	response, err := so.service.Spreadsheets.Values.Update(so.spreadsheetId, fmt.Sprintf("%s!A1", sheetName), rb).ValueInputOption(VIO_RAW).Do()
+	if err != nil || response.HTTPStatusCode != 200 {
+		log.Printf("Unable to write sheet: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package groupme
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+const (
+	DirectMessagesURL = "https://api.groupme.com/v3/direct_messages"
+)
+
+type DirectMessage struct {
+	SourceGuid  string       `json:"source_guid"`
+	RecipientId string       `json:"recipient_id"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type directMessageRequest struct {
+	DirectMessage DirectMessage `json:"direct_message"`
+}
+
+// NewDirectMessageService sends direct messages on behalf of the user owning
+// the token, the same token ImageService uses
+func NewDirectMessageService(userToken string) *DirectMessageService {
+	return &DirectMessageService{
+		userToken: userToken,
+	}
+}
+
+type DirectMessageService struct {
+	userToken string
+}
+
+func (dms *DirectMessageService) SendMessage(userID, text, imageURL string) error {
+	var attachments []Attachment
+	if imageURL != "" {
+		attachments = append(attachments, Attachment{
+			Type: "image",
+			URL:  imageURL,
+		})
+	}
+	guid, err := sourceGuid()
+	if err != nil {
+		log.Printf("Can't generate source guid: %v\n", err)
+		return err
+	}
+	body, err := json.Marshal(&directMessageRequest{
+		DirectMessage: DirectMessage{
+			SourceGuid:  guid,
+			RecipientId: userID,
+			Text:        text,
+			Attachments: attachments,
+		},
+	})
+	if err != nil {
+		log.Printf("Can't marshal the message: %v\n", err)
+		return err
+	}
+
+	r, err := http.NewRequest("POST", DirectMessagesURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Can't create request %v\n", err)
+		return err
+	}
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("X-Access-Token", dms.userToken)
+	client := &http.Client{}
+	response, err := client.Do(r)
+	if err != nil {
+		log.Printf("Error sending the direct message: %v\n", err)
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		responseBody, _ := io.ReadAll(response.Body)
+		log.Printf("Unexpected return code: %d, body: %s\n", response.StatusCode, string(responseBody))
+		return fmt.Errorf("direct message rejected by GroupMe, status code: %d", response.StatusCode)
+	}
+	return nil
+}
+
+func sourceGuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
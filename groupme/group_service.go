@@ -0,0 +1,107 @@
+package groupme
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	GroupsURL             = "https://api.groupme.com/v3/groups"
+	VERIFY_MESSAGES_LIMIT = 20
+)
+
+type GroupMessage struct {
+	Id          string       `json:"id"`
+	GroupId     string       `json:"group_id"`
+	SenderId    string       `json:"sender_id"`
+	SenderType  string       `json:"sender_type"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type MessagesResponse struct {
+	Response struct {
+		Count    int            `json:"count"`
+		Messages []GroupMessage `json:"messages"`
+	} `json:"response"`
+}
+
+func NewGroupService(userToken string) *GroupService {
+	return &GroupService{
+		userToken: userToken,
+	}
+}
+
+type GroupService struct {
+	userToken string
+}
+
+// GetMessages returns the latest messages of the group, newest first
+func (gs *GroupService) GetMessages(groupID string, limit int) ([]GroupMessage, error) {
+	r, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/messages?limit=%d", GroupsURL, groupID, limit), nil)
+	if err != nil {
+		log.Printf("Can't create request %v\n", err)
+		return nil, err
+	}
+	r.Header.Add("X-Access-Token", gs.userToken)
+	client := &http.Client{}
+	response, err := client.Do(r)
+	if err != nil {
+		log.Printf("Get messages error %v\n", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		log.Printf("Get messages returned unexpected code: %d\n", response.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	}
+
+	messagesResponse := MessagesResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&messagesResponse); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return nil, err
+	}
+	return messagesResponse.Response.Messages, nil
+}
+
+// MessageExists checks that the message is among the latest messages of the
+// group and that it was sent by the given user with the same text and
+// attachments, so forged mentions are rejected too
+func (gs *GroupService) MessageExists(groupID, messageID, senderID, text string, attachments []Attachment) (bool, error) {
+	messages, err := gs.GetMessages(groupID, VERIFY_MESSAGES_LIMIT)
+	if err != nil {
+		return false, err
+	}
+	for _, message := range messages {
+		if message.Id == messageID {
+			return message.SenderId == senderID && message.Text == text && sameAttachments(message.Attachments, attachments), nil
+		}
+	}
+	return false, nil
+}
+
+// sameAttachments compares types, URLs, mentioned users and their loci of the
+// attachments in order
+func sameAttachments(a, b []Attachment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].URL != b[i].URL || !slices.Equal(a[i].UserIDs, b[i].UserIDs) {
+			return false
+		}
+		if len(a[i].Loci) != len(b[i].Loci) {
+			return false
+		}
+		for j := range a[i].Loci {
+			if !slices.Equal(a[i].Loci[j], b[i].Loci[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
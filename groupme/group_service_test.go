@@ -0,0 +1,27 @@
+package groupme
+
+import "testing"
+
+func TestSameAttachments(t *testing.T) {
+	mention := Attachment{Type: "mentions", UserIDs: []string{"1", "2"}, Loci: [][]int{{0, 4}, {7, 5}}}
+	image := Attachment{Type: "image", URL: "https://i.groupme.com/1.png"}
+	tests := []struct {
+		name string
+		a, b []Attachment
+		want bool
+	}{
+		{"none", nil, []Attachment{}, true},
+		{"same", []Attachment{image, mention}, []Attachment{image, mention}, true},
+		{"missing attachment", []Attachment{image, mention}, []Attachment{image}, false},
+		{"other user", []Attachment{mention}, []Attachment{{Type: "mentions", UserIDs: []string{"1", "3"}, Loci: [][]int{{0, 4}, {7, 5}}}}, false},
+		{"other locus", []Attachment{mention}, []Attachment{{Type: "mentions", UserIDs: []string{"1", "2"}, Loci: [][]int{{0, 4}, {8, 5}}}}, false},
+		{"other url", []Attachment{image}, []Attachment{{Type: "image", URL: "https://i.groupme.com/2.png"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameAttachments(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameAttachments() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,44 +3,108 @@ package groupme
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 const (
 	BotURL = "https://api.groupme.com/v3/bots/post"
+
+	MESSAGE_MAX_LENGTH = 1000
+	// room for the "(1/10) " part number
+	PART_PREFIX_LENGTH = 10
 )
 
-type ImageAttachment struct {
-	Type string `json:"type"`
-	URL  string `json:"url"`
+type Attachment struct {
+	Type    string   `json:"type"`
+	URL     string   `json:"url,omitempty"`
+	UserIDs []string `json:"user_ids,omitempty"`
+	Loci    [][]int  `json:"loci,omitempty"`
 }
 
 type Message struct {
-	BotId       string            `json:"bot_id"`
-	Text        string            `json:"text"`
-	Attachments []ImageAttachment `json:"attachments"`
+	BotId       string       `json:"bot_id"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Mention pings the user wherever Text (e.g. "@Novák") appears in the message
+type Mention struct {
+	UserID string
+	Text   string
 }
 
 func NewMessageService(botToken string) *MessageService {
 	sender := &MessageService{
-		botId: botToken,
+		botId:     botToken,
+		groupBots: map[string]string{},
 	}
 	return sender
 }
 
 type MessageService struct {
-	botId string
+	botId     string
+	groupBots map[string]string
+}
+
+// SetGroupBot registers the bot posting replies to the group, it's meant to
+// be called during initialization only
+func (ms *MessageService) SetGroupBot(groupID, botID string) {
+	ms.groupBots[groupID] = botID
 }
 
+// ForGroup returns a service posting via the bot of the group, the default
+// bot is used for unknown groups
+func (ms *MessageService) ForGroup(groupID string) *MessageService {
+	botID, ok := ms.groupBots[groupID]
+	if !ok {
+		return ms
+	}
+	return &MessageService{
+		botId:     botID,
+		groupBots: ms.groupBots,
+	}
+}
+
+// SendMessage posts the text, texts over the GroupMe limit are split at line
+// boundaries into numbered parts, the image is attached to the first one
 func (ms *MessageService) SendMessage(text, imageURL string) error {
-	var attachments []ImageAttachment
+	return ms.SendMessageWithMentions(text, imageURL, nil)
+}
+
+// SendMessageWithMentions posts the text like SendMessage and adds mentions
+// attachment to every part containing some of the mentions
+func (ms *MessageService) SendMessageWithMentions(text, imageURL string, mentions []Mention) error {
+	parts := splitText(text, MESSAGE_MAX_LENGTH-PART_PREFIX_LENGTH)
+	for i, part := range parts {
+		if len(parts) > 1 {
+			part = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), part)
+		}
+		if err := ms.post(part, imageURL, mentions); err != nil {
+			return err
+		}
+		imageURL = ""
+	}
+	return nil
+}
+
+func (ms *MessageService) post(text, imageURL string, mentions []Mention) error {
+	var attachments []Attachment
 	if imageURL != "" {
-		attachments = append(attachments, ImageAttachment{
+		attachments = append(attachments, Attachment{
 			Type: "image",
 			URL:  imageURL,
 		})
 	}
+	if mentionsAttachment, ok := mentionsAttachment(text, mentions); ok {
+		attachments = append(attachments, mentionsAttachment)
+	}
 	message := &Message{
 		BotId:       ms.botId,
 		Text:        text,
@@ -48,6 +112,10 @@ func (ms *MessageService) SendMessage(text, imageURL string) error {
 	}
 
 	body, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Can't marshal the message: %v\n", err)
+		return err
+	}
 
 	client := &http.Client{}
 	response, err := client.Post(BotURL, "application/json", bytes.NewReader(body))
@@ -57,7 +125,76 @@ func (ms *MessageService) SendMessage(text, imageURL string) error {
 	}
 	defer response.Body.Close()
 	if response.StatusCode != http.StatusAccepted {
-		log.Printf("Unexpected return code: %d\n", response.StatusCode)
+		responseBody, _ := io.ReadAll(response.Body)
+		log.Printf("Unexpected return code: %d, body: %s\n", response.StatusCode, string(responseBody))
+		return fmt.Errorf("message rejected by GroupMe, status code: %d", response.StatusCode)
 	}
 	return nil
 }
+
+// splitText splits the text into parts of at most limit characters, lines are
+// kept together unless a single line is over the limit
+func splitText(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	var parts []string
+	current := ""
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for utf8.RuneCountInString(line) > limit {
+			if current != "" {
+				parts = append(parts, strings.TrimSuffix(current, "\n"))
+				current = ""
+			}
+			runes := []rune(line)
+			cut := limit
+			if space := strings.LastIndex(string(runes[:limit]), " "); space > 0 {
+				cut = utf8.RuneCountInString(string(runes[:limit])[:space]) + 1
+			}
+			parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+			line = string(runes[cut:])
+		}
+		if utf8.RuneCountInString(current)+utf8.RuneCountInString(line) > limit {
+			parts = append(parts, strings.TrimSuffix(current, "\n"))
+			current = ""
+		}
+		current += line
+	}
+	if strings.TrimSpace(current) != "" {
+		parts = append(parts, strings.TrimSuffix(current, "\n"))
+	}
+	return parts
+}
+
+// mentionsAttachment locates mentions in the text, loci are [start, length]
+// pairs counted in UTF-16 code units as GroupMe clients do
+func mentionsAttachment(text string, mentions []Mention) (Attachment, bool) {
+	attachment := Attachment{
+		Type: "mentions",
+	}
+	for _, mention := range mentions {
+		if mention.Text == "" {
+			continue
+		}
+		offset := 0
+		for {
+			i := strings.Index(text[offset:], mention.Text)
+			if i == -1 {
+				break
+			}
+			start := offset + i
+			offset = start + len(mention.Text)
+			// "@Jan" must not match the beginning of "@Jana"
+			if next, _ := utf8.DecodeRuneInString(text[offset:]); unicode.IsLetter(next) || unicode.IsDigit(next) {
+				continue
+			}
+			attachment.UserIDs = append(attachment.UserIDs, mention.UserID)
+			attachment.Loci = append(attachment.Loci, []int{utf16Length(text[:start]), utf16Length(mention.Text)})
+		}
+	}
+	return attachment, len(attachment.UserIDs) > 0
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,83 @@
+package groupme
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "hokej", 10, []string{"hokej"}},
+		{"lines kept together", "aaa\nbbb\nccc", 8, []string{"aaa\nbbb", "ccc"}},
+		{"long line split on space", "hello world foo", 8, []string{"hello", "world", "foo"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"counted in characters", "žluťoučký kůň", 10, []string{"žluťoučký", "kůň"}},
+		{"long line between lines", "a\nbbbbbb\nc", 4, []string{"a", "bbbb", "bb\nc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitText(tt.text, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentionsAttachment(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		mentions []Mention
+		want     Attachment
+		wantOk   bool
+	}{
+		{
+			name:     "diacritics",
+			text:     "@Novák zaplať",
+			mentions: []Mention{{UserID: "1", Text: "@Novák"}},
+			want:     Attachment{Type: "mentions", UserIDs: []string{"1"}, Loci: [][]int{{0, 6}}},
+			wantOk:   true,
+		},
+		{
+			name:     "emoji counts as two UTF-16 units",
+			text:     "🏒 @Jan",
+			mentions: []Mention{{UserID: "1", Text: "@Jan"}},
+			want:     Attachment{Type: "mentions", UserIDs: []string{"1"}, Loci: [][]int{{3, 4}}},
+			wantOk:   true,
+		},
+		{
+			name:     "repeated mentions",
+			text:     "@Jan a @Petr a @Jan",
+			mentions: []Mention{{UserID: "1", Text: "@Jan"}, {UserID: "2", Text: "@Petr"}},
+			want:     Attachment{Type: "mentions", UserIDs: []string{"1", "1", "2"}, Loci: [][]int{{0, 4}, {15, 4}, {7, 5}}},
+			wantOk:   true,
+		},
+		{
+			name:     "prefix of longer name",
+			text:     "@Jana a @Jan, @Jan2",
+			mentions: []Mention{{UserID: "1", Text: "@Jan"}, {UserID: "2", Text: "@Jana"}},
+			want:     Attachment{Type: "mentions", UserIDs: []string{"1", "2"}, Loci: [][]int{{8, 4}, {0, 5}}},
+			wantOk:   true,
+		},
+		{
+			name:     "not in text",
+			text:     "hokej",
+			mentions: []Mention{{UserID: "1", Text: "@Jan"}, {UserID: "2", Text: ""}},
+			want:     Attachment{Type: "mentions"},
+			wantOk:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mentionsAttachment(tt.text, tt.mentions)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mentionsAttachment() = %+v, %t, want %+v, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
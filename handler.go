@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gamebtc/devicedetector"
 	"github.com/gamebtc/devicedetector/parser"
@@ -13,11 +16,23 @@ import (
 	"github.com/vlcak/groupme_qr_bot/google"
 	"github.com/vlcak/groupme_qr_bot/groupme"
 	"github.com/vlcak/groupme_qr_bot/tymuj"
+	"github.com/vlcak/groupme_qr_bot/utils"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	SENDER_TYPE_USER   = "user"
+	MAX_STATEMENT_SIZE = 10 << 20
 )
 
 type Handler struct {
 	handler           *http.ServeMux
 	messageProcessor  *MessageProcessor
+	cronWorker        *CronWorker
+	jobQueue          *JobQueue
+	groupService      *groupme.GroupService
+	callbackToken     string
+	groupIDs          []string
 	deviceDetector    *devicedetector.DeviceDetector
 	accountURL        string
 	paymentsURL       string
@@ -31,16 +46,35 @@ func NewHandler(
 	newRelicApp *newrelic.Application,
 	imageService *groupme.ImageService,
 	messageService *groupme.MessageService,
+	directMessageService *groupme.DirectMessageService,
 	tymujClient *tymuj.Client,
 	sheetOperator *google.SheetOperator,
 	driveOperator *google.DriveOperator,
 	botID string,
 	dbClient *database.Client,
 	bankClient *bank.CsobClient,
+	cronWorker *CronWorker,
+	ledger *Ledger,
 	deviceDetectorRegexes string,
+	adminIDs []string,
+	groupCommands map[string][]string,
+	eurRate float64,
+	qrRenderer *utils.QRRenderer,
+	callbackToken string,
+	groupIDs []string,
+	groupService *groupme.GroupService,
+	workers int,
+	queueSize int,
 ) *Handler {
-	h := &Handler{}
-	h.messageProcessor = NewMessageProcessor(imageService, messageService, tymujClient, sheetOperator, driveOperator, botID, dbClient)
+	h := &Handler{
+		cronWorker:    cronWorker,
+		callbackToken: callbackToken,
+		groupIDs:      groupIDs,
+		groupService:  groupService,
+	}
+	h.messageProcessor = NewMessageProcessor(imageService, messageService, directMessageService, tymujClient, sheetOperator, driveOperator, ledger, botID, dbClient, adminIDs, groupCommands, eurRate, qrRenderer)
+	h.jobQueue = NewJobQueue(h.messageProcessor, workers, queueSize)
+	h.messageProcessor.jobQueue = h.jobQueue
 	h.accountURL = bankClient.GetAccountURL()
 	h.paymentsURL = sheetOperator.GetReadOnlyURL()
 	h.mobilePaymentsURL = sheetOperator.GetReadOnlyURLToSheet(1)
@@ -48,6 +82,14 @@ func NewHandler(
 	h.handler = http.NewServeMux()
 	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/", h.getRoot))
 	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/message", h.messageReceived))
+	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/message/", h.messageReceived))
+	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/qr", h.renderQR))
+	// imported payments are credited to the ledger, so the import is never open
+	if callbackToken != "" {
+		h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/import", h.importStatement))
+	} else {
+		log.Printf("No callback token, statement import disabled")
+	}
 	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/platby", h.redirectToPaymetns))
 	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/tymuj", h.redirectToTymuj))
 	h.handler.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/ucet", h.redirectToAccount))
@@ -90,10 +132,135 @@ func (h *Handler) getRoot(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) messageReceived(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Got MESSAGE request\n")
-	h.messageProcessor.ProcessMessage(r.Body)
+	if !h.validToken(r) {
+		log.Printf("Invalid callback token from %s\n", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	m := GroupmeMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		log.Printf("ERROR: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(h.groupIDs) > 0 && !slices.Contains(h.groupIDs, m.GroupId) {
+		log.Printf("Message from unknown group: %s\n", m.GroupId)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// GroupMe sends bot and system messages to the callback as well
+	if m.SenderType != SENDER_TYPE_USER {
+		log.Printf("Ignoring message from %s\n", m.SenderType)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if h.groupService != nil {
+		exists, err := h.groupService.MessageExists(m.GroupId, m.Id, m.SenderId, m.Text, m.Attachments)
+		if err != nil {
+			log.Printf("Can't verify message %s: %v\n", m.Id, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if !exists {
+			log.Printf("Message %s not found in group %s\n", m.Id, m.GroupId)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	// Respond right away, long running commands would make GroupMe retry the callback
+	if err := h.jobQueue.Enqueue(m); err == ErrQueueFull {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// validToken accepts the callback token either as the last path segment
+// (/message/<token>) or as the token query parameter
+func (h *Handler) validToken(r *http.Request) bool {
+	if h.callbackToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/message"), "/")
+	if token == "" || !strings.HasPrefix(r.URL.Path, "/message") {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.callbackToken)) == 1
+}
+
+// renderQR renders printable payment QR code, e.g.
+// /qr?account=123456789/0300&amount=250&vs=1001&message=hokej&format=pdf
+func (h *Handler) renderQR(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Got QR request\n")
+	if !h.validToken(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = utils.QR_FORMAT_PNG
+	}
+	contentType := utils.QRFormatContentType(format)
+	if contentType == "" {
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+	amount, err := utils.ParseAmount(query.Get("amount"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	image, err := h.messageProcessor.paymentGenerator.RenderSPD(utils.SPDPayment{
+		Account:        query.Get("account"),
+		Amount:         amount,
+		Message:        query.Get("message"),
+		VariableSymbol: query.Get("vs"),
+	}, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(image)
+}
+
+// importStatement imports bank statement (CAMT.053, ABO/GPC or CSV) sent as
+// request body or as multipart form file "statement", e.g.
+// curl -F statement=@vypis.gpc https://.../import?token=...
+func (h *Handler) importStatement(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Got IMPORT request\n")
+	if h.callbackToken == "" || !h.validToken(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body := io.LimitReader(r.Body, MAX_STATEMENT_SIZE)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("statement")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = io.LimitReader(file, MAX_STATEMENT_SIZE)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	summary, err := h.cronWorker.ImportStatement(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	io.WriteString(w, summary+"\n")
+}
+
 func (h *Handler) redirectToAccount(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Got ACCOUNT request\n")
 	http.Redirect(w, r, h.accountURL, http.StatusFound)
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SEEN_MESSAGES_EXPIRATION = time.Hour
+)
+
+var (
+	ErrDuplicateMessage = errors.New("duplicate message")
+	ErrQueueFull        = errors.New("queue full")
+	ErrCommandRunning   = errors.New("command still running")
+)
+
+type Job struct {
+	Message  GroupmeMessage
+	Command  string
+	Enqueued time.Time
+	Started  time.Time
+}
+
+// NewJobQueue starts the workers processing messages in background
+func NewJobQueue(messageProcessor *MessageProcessor, workers, size int) *JobQueue {
+	q := &JobQueue{
+		messageProcessor: messageProcessor,
+		jobs:             make(chan *Job, size),
+		seen:             map[string]time.Time{},
+		active:           map[string]int{},
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+type JobQueue struct {
+	messageProcessor *MessageProcessor
+	jobs             chan *Job
+	mutex            sync.Mutex
+	seen             map[string]time.Time
+	// active counts queued and running jobs per command
+	active  map[string]int
+	running []*Job
+}
+
+// Enqueue schedules the message for processing, duplicate messages and
+// commands over their concurrency limit are rejected. Immediate commands are
+// processed right away.
+func (q *JobQueue) Enqueue(m GroupmeMessage) error {
+	if command := q.messageProcessor.commandOf(m); command != nil && command.Immediate {
+		return q.messageProcessor.ProcessMessage(m)
+	}
+	command, err := q.enqueue(m)
+	ms := q.messageProcessor.messageService.ForGroup(m.GroupId)
+	switch err {
+	case ErrCommandRunning:
+		ms.SendMessage(fmt.Sprintf("%s still running, try again later", command), "")
+	case ErrQueueFull:
+		ms.SendMessage("Too many commands in progress, try again later", "")
+	}
+	return err
+}
+
+func (q *JobQueue) enqueue(m GroupmeMessage) (string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	for id, seen := range q.seen {
+		if now.Sub(seen) > SEEN_MESSAGES_EXPIRATION {
+			delete(q.seen, id)
+		}
+	}
+	if m.Id != "" {
+		if _, ok := q.seen[m.Id]; ok {
+			log.Printf("Duplicate message %s\n", m.Id)
+			return "", ErrDuplicateMessage
+		}
+	}
+
+	job := &Job{
+		Message:  m,
+		Enqueued: now,
+	}
+	if command := q.messageProcessor.commandOf(m); command != nil {
+		job.Command = command.Name
+		if command.MaxConcurrent > 0 && q.active[command.Name] >= command.MaxConcurrent {
+			log.Printf("%s still running, rejecting message %s\n", command.Name, m.Id)
+			return command.Name, ErrCommandRunning
+		}
+	}
+
+	select {
+	case q.jobs <- job:
+	default:
+		log.Printf("Queue full, rejecting message %s\n", m.Id)
+		return job.Command, ErrQueueFull
+	}
+	if m.Id != "" {
+		q.seen[m.Id] = now
+	}
+	q.active[job.Command]++
+	return job.Command, nil
+}
+
+func (q *JobQueue) work() {
+	for job := range q.jobs {
+		q.start(job)
+		log.Printf("Processing message %s, command: %s, waited %s\n", job.Message.Id, job.Command, job.Started.Sub(job.Enqueued))
+		if err := q.messageProcessor.ProcessMessage(job.Message); err != nil {
+			log.Printf("Can't process message %s: %v\n", job.Message.Id, err)
+		}
+		q.finish(job)
+	}
+}
+
+func (q *JobQueue) start(job *Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job.Started = time.Now()
+	q.running = append(q.running, job)
+}
+
+func (q *JobQueue) finish(job *Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.active[job.Command]--
+	for i, j := range q.running {
+		if j == job {
+			q.running = append(q.running[:i], q.running[i+1:]...)
+			break
+		}
+	}
+}
+
+// Status describes running and queued commands, e.g. "LINEUP still running (1m20s)"
+func (q *JobQueue) Status() string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var lines []string
+	for _, job := range q.running {
+		if job.Command == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s still running (%s)", job.Command, time.Since(job.Started).Round(time.Second)))
+	}
+	sort.Strings(lines)
+	if queued := len(q.jobs); queued > 0 {
+		lines = append(lines, fmt.Sprintf("%d commands queued", queued))
+	}
+	if len(lines) == 0 {
+		return "Nothing running"
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vlcak/groupme_qr_bot/bank"
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/google"
+	"github.com/vlcak/groupme_qr_bot/groupme"
+)
+
+const (
+	LEDGER_SHEET       = "Sheet1"
+	LEDGER_DATE_FORMAT = "2.1.2006"
+	OPENING_BALANCE    = "opening balance"
+)
+
+// ErrLedgerNotInitialized is returned by the writes until LEDGER_INIT imports
+// the opening balances, so the sheet isn't overwritten before the import
+var ErrLedgerNotInitialized = errors.New("ledger is not initialized, run LEDGER_INIT first")
+
+func NewLedger(db *database.Client, sheetOperator *google.SheetOperator) *Ledger {
+	return &Ledger{
+		db:            db,
+		sheetOperator: sheetOperator,
+	}
+}
+
+// Ledger keeps player balances in DB as credits, debits and adjustments, the
+// Google sheet is only a projection regenerated by Project
+type Ledger struct {
+	db            *database.Client
+	sheetOperator *google.SheetOperator
+	// projectMutex prevents concurrent regenerations from mixing the rows
+	projectMutex sync.Mutex
+	// initialized caches that the ledger was initialized, it can't be undone
+	initialized atomic.Bool
+}
+
+// CheckInitialized returns ErrLedgerNotInitialized until the opening balances
+// are imported
+func (l *Ledger) CheckInitialized() error {
+	if l.initialized.Load() {
+		return nil
+	}
+	initialized, err := l.db.IsLedgerInitialized()
+	if err != nil {
+		return err
+	}
+	if !initialized {
+		return ErrLedgerNotInitialized
+	}
+	l.initialized.Store(true)
+	return nil
+}
+
+// PlayerNames returns names of the players balances are kept for, hosts are
+// not included
+func (l *Ledger) PlayerNames() ([]string, error) {
+	players, err := l.db.GetPlayers()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(players))
+	for _, player := range players {
+		names = append(names, player.Name.String)
+	}
+	return names, nil
+}
+
+func (l *Ledger) Balances() (map[string]int, error) {
+	balances, err := l.db.GetBalances()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]int{}
+	for _, balance := range balances {
+		result[balance.Name.String] = int(balance.Balance.Int64)
+	}
+	return result, nil
+}
+
+// Entries returns entries of the player created in [from, to)
+func (l *Ledger) Entries(name string, from, to time.Time) ([]database.LedgerEntry, error) {
+	return l.db.GetLedgerEntries(name, from, to)
+}
+
+func (l *Ledger) Credit(payment bank.Payment, name string) error {
+	description := fmt.Sprintf("%s %s", payment.Name, payment.Message)
+	// reprocessed payments don't have the accounting date
+	createdAt := payment.Timestamp
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if err := l.CheckInitialized(); err != nil {
+		return err
+	}
+	return l.db.AddCredit(name, description, payment.Amount, payment.Order, createdAt)
+}
+
+func (l *Ledger) Charge(names []string, amount int, description, createdBy string) error {
+	if err := l.CheckInitialized(); err != nil {
+		return err
+	}
+	return l.db.AddDebits(names, amount, description, createdBy)
+}
+
+func (l *Ledger) Adjust(name string, amount int, description, createdBy string) error {
+	if err := l.CheckInitialized(); err != nil {
+		return err
+	}
+	return l.db.AddAdjustment(name, amount, description, createdBy)
+}
+
+// Project regenerates the sheet: header with names, total payments, balances
+// and a row for every charge or adjustment
+func (l *Ledger) Project() error {
+	// the sheet holds the only copy of the balances until they are imported
+	if err := l.CheckInitialized(); err != nil {
+		return err
+	}
+	l.projectMutex.Lock()
+	defer l.projectMutex.Unlock()
+
+	names, err := l.PlayerNames()
+	if err != nil {
+		log.Printf("Can't get player names: %v", err)
+		return err
+	}
+	balances, err := l.db.GetBalances()
+	if err != nil {
+		log.Printf("Can't get balances: %v", err)
+		return err
+	}
+	entries, err := l.db.GetLedgerEntries("", time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		log.Printf("Can't get ledger entries: %v", err)
+		return err
+	}
+
+	// players removed from the team keep their column while they have entries
+	columns := map[string]int{}
+	for _, name := range names {
+		columns[name] = len(columns)
+	}
+	for _, balance := range balances {
+		if _, ok := columns[balance.Name.String]; !ok && balance.Name.String != google.HOSTS {
+			names = append(names, balance.Name.String)
+			columns[balance.Name.String] = len(columns)
+		}
+	}
+	names = append(names, google.HOSTS)
+	columns[google.HOSTS] = len(columns)
+
+	header := []interface{}{"Akce", "Datum", "Částka"}
+	credits := []interface{}{"Platby", "", ""}
+	remainings := []interface{}{"Zůstatek", "", ""}
+	for _, name := range names {
+		header = append(header, name)
+		credits = append(credits, 0)
+		remainings = append(remainings, 0)
+	}
+	for _, balance := range balances {
+		credits[3+columns[balance.Name.String]] = balance.Credits.Int64
+		remainings[3+columns[balance.Name.String]] = balance.Balance.Int64
+	}
+	rows := [][]interface{}{header, credits, remainings}
+
+	// entries created together, e.g. charges of one event, share a row
+	var row []interface{}
+	var rowKey string
+	for _, entry := range entries {
+		if entry.EntryType.String == database.LEDGER_CREDIT {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%d", entry.EntryType.String, entry.Description.String, entry.CreatedAt.Time.UnixNano())
+		if key != rowKey {
+			if row != nil {
+				rows = append(rows, row)
+			}
+			rowKey = key
+			row = make([]interface{}, 3+len(names))
+			row[0] = entry.Description.String
+			row[1] = entry.CreatedAt.Time.Format(LEDGER_DATE_FORMAT)
+			row[2] = ""
+			if entry.EntryType.String == database.LEDGER_DEBIT {
+				row[2] = -entry.Amount.Int64
+			}
+			for i := 3; i < len(row); i++ {
+				row[i] = ""
+			}
+		}
+		row[3+columns[entry.Name.String]] = entry.Amount.Int64
+	}
+	if row != nil {
+		rows = append(rows, row)
+	}
+	return l.sheetOperator.Replace(LEDGER_SHEET, rows)
+}
+
+// initFromSheet stores balances from the sheet row 3 as opening balances, it
+// is allowed only once, before anything else is written to the ledger
+func (l *Ledger) initFromSheet(ms *groupme.MessageService, createdBy string) error {
+	if err := l.CheckInitialized(); err == nil {
+		return errors.New("ledger is already initialized")
+	} else if !errors.Is(err, ErrLedgerNotInitialized) {
+		return err
+	}
+	names, err := l.sheetOperator.Get(LEDGER_SHEET+"!D1:1", "", false)
+	if err != nil {
+		return err
+	}
+	remainings, err := l.sheetOperator.Get(LEDGER_SHEET+"!D3:3", "", false)
+	if err != nil {
+		return err
+	}
+	balances, err := openingBalances(names, remainings)
+	if err != nil {
+		return err
+	}
+	if err := l.db.InitLedger(balances, OPENING_BALANCE, createdBy); err != nil {
+		return err
+	}
+	l.initialized.Store(true)
+	if err := l.Project(); err != nil {
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Opening balances imported: %d", len(balances)), "")
+}
+
+// openingBalances pairs the sheet header names with the balances row, players
+// with zero balance are left out
+func openingBalances(names, remainings []string) (map[string]int, error) {
+	balances := map[string]int{}
+	for i, name := range names {
+		// empty balance cells are zero, the row ends at the last non-empty cell
+		if name == "" || i >= len(remainings) || strings.TrimSpace(remainings[i]) == "" {
+			continue
+		}
+		remaining, err := strconv.ParseFloat(strings.TrimSpace(remainings[i]), 64)
+		if err != nil {
+			log.Printf("Can't parse %s to number %v\n", remainings[i], err)
+			return nil, fmt.Errorf("invalid balance of %s: %s", name, remainings[i])
+		}
+		if int(remaining) != 0 {
+			balances[name] = int(remaining)
+		}
+	}
+	return balances, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpeningBalances(t *testing.T) {
+	tests := []struct {
+		name       string
+		names      []string
+		remainings []string
+		want       map[string]int
+		wantErr    bool
+	}{
+		{
+			name:       "balances",
+			names:      []string{"Novák", "Svoboda", "Dvořák"},
+			remainings: []string{"250", "-300", "0"},
+			want:       map[string]int{"Novák": 250, "Svoboda": -300},
+		},
+		{
+			name:       "empty cells are zero",
+			names:      []string{"Novák", "Svoboda", "Dvořák", "Hosté"},
+			remainings: []string{"", "-300", " "},
+			want:       map[string]int{"Svoboda": -300},
+		},
+		{
+			name:       "columns without name",
+			names:      []string{"Novák", "", "Dvořák"},
+			remainings: []string{"100", "200", "300.0"},
+			want:       map[string]int{"Novák": 100, "Dvořák": 300},
+		},
+		{
+			name:       "invalid balance",
+			names:      []string{"Novák"},
+			remainings: []string{"abc"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openingBalances(tt.names, tt.remainings)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("openingBalances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
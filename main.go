@@ -5,9 +5,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/newrelic/go-agent/v3/newrelic"
@@ -17,21 +19,37 @@ import (
 	"github.com/vlcak/groupme_qr_bot/google"
 	"github.com/vlcak/groupme_qr_bot/groupme"
 	"github.com/vlcak/groupme_qr_bot/tymuj"
+	"github.com/vlcak/groupme_qr_bot/utils"
 )
 
 var (
 	flagBotToken        = flag.String("bot-token", "", "Bot TOKEN")
 	flagBotID           = flag.String("bot-id", "", "Bot ID")
 	flagPort            = flag.String("port", ":80", "Service address (e.g. :80)")
-	flagUserToken       = flag.String("user-token", "", "User token for images")
+	flagUserToken       = flag.String("user-token", "", "User token for images and direct messages")
+	flagDirectMessages  = flag.Bool("direct-messages", true, "Send personal payment QR codes via direct messages")
 	flagDbURL           = flag.String("db", "", "Database URL")
 	flagTymujLogin      = flag.String("tymuj-login", "", "Tymuj login")
 	flagTymujPassword   = flag.String("tymuj-password", "", "Tymuj password")
 	flagTymujTeamID     = flag.Int("tymuj-team-id", 33489, "Tymuj team ID")
+	flagFioToken        = flag.String("fio-token", "", "Fio API token, payments are read from Fio instead of ČSOB when set")
+	flagFioURL          = flag.String("fio-url", bank.FIO_BASE_URL, "Fio API base URL")
 	flagGoogleSheetID   = flag.String("google-sheet-id", "", "Google sheet ID")
 	flagAccountNumber   = flag.Int("account-number", 311396620, "Account number")
 	flagNewRelicLicense = flag.String("newrelic-license", "", "NewRelic license")
 	flagDeviceDetector  = flag.String("device-detector-regexes", "regexes", "Folder with device detector regexes")
+	flagAdminUserIDs    = flag.String("admin-user-ids", "", "Comma separated GroupMe user IDs with admin role")
+	flagCallbackToken   = flag.String("callback-token", "", "Secret token expected in the callback URL (/message/<token> or ?token=)")
+	flagGroupIDs        = flag.String("group-ids", "", "Comma separated GroupMe group IDs the commands are accepted from, groups with own bot are added automatically")
+	flagVerifyMessages  = flag.Bool("verify-messages", false, "Verify received messages via GroupMe API")
+	flagEURRate         = flag.Float64("eur-rate", 25.0, "CZK per EUR used for EPC (SEPA) payment QR codes")
+	flagQRLogo          = flag.String("qr-logo", "", "PNG or JPEG logo placed in the centre of payment QR codes")
+	flagQRSize          = flag.Int("qr-size", 250, "Width of PNG payment QR codes in pixels")
+	flagWorkers         = flag.Int("workers", 4, "Number of workers processing commands")
+	flagQueueSize       = flag.Int("queue-size", 32, "Maximal number of queued commands")
+	flagDebtThreshold   = flag.Int("reminder-threshold", 0, "Players with balance below the threshold get weekly debt reminders")
+	flagDebtEscalation  = flag.Int("reminder-escalation-weeks", 3, "Debtors reminded this many weeks in a row are mentioned in the group, 0 disables escalation")
+	flagDebtAccount     = flag.String("reminder-account", "", "Account the debts are paid to, ČSOB account from -account-number by default")
 )
 
 func main() {
@@ -46,8 +64,30 @@ func main() {
 	}
 	imageService := groupme.NewImageService(*flagUserToken)
 	messageService := groupme.NewMessageService(*flagBotToken)
+	var directMessageService *groupme.DirectMessageService
+	if *flagDirectMessages && *flagUserToken != "" {
+		directMessageService = groupme.NewDirectMessageService(*flagUserToken)
+	}
 	tymujClient := tymuj.NewClient(*flagTymujLogin, *flagTymujPassword, *flagTymujTeamID)
 	dbClient := database.NewClient(*flagDbURL)
+	if err := dbClient.Migrate(); err != nil {
+		log.Fatalf("Can't migrate DB: %v", err)
+	}
+	groupIDs := splitList(*flagGroupIDs)
+	groupCommands := map[string][]string{}
+	groups, err := dbClient.GetGroups()
+	if err != nil {
+		log.Printf("Can't load groups: %v", err)
+	}
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.GroupID.String)
+		if group.BotID.String != "" {
+			messageService.SetGroupBot(group.GroupID.String, group.BotID.String)
+		}
+		if commands := splitList(strings.ToUpper(group.Commands.String)); len(commands) > 0 {
+			groupCommands[group.GroupID.String] = commands
+		}
+	}
 	ctx := context.Background()
 	sheetOperator, err := google.NewSheetOperator(ctx, *flagGoogleSheetID)
 	if err != nil {
@@ -57,22 +97,51 @@ func main() {
 	if err != nil {
 		log.Printf("Can't initialize Google sheet client: %v", err)
 	}
+	var qrLogo image.Image
+	if *flagQRLogo != "" {
+		if qrLogo, err = utils.LoadLogo(*flagQRLogo); err != nil {
+			log.Printf("Can't load QR logo: %v", err)
+		}
+	}
+	qrRenderer := utils.NewQRRenderer(*flagQRSize, qrLogo)
 	csobClient := bank.NewCsobClient(*flagAccountNumber, dbClient)
 
-	cronWorker := NewCronWorker(csobClient, sheetOperator, tymujClient, messageService, dbClient)
+	var paymentSource bank.PaymentSource = csobClient
+	if *flagFioToken != "" {
+		paymentSource = bank.NewFioClient(*flagFioURL, *flagFioToken)
+	}
+
+	ledger := NewLedger(dbClient, sheetOperator)
+	reminders := DebtReminderConfig{
+		Account:         *flagDebtAccount,
+		Threshold:       *flagDebtThreshold,
+		EscalationWeeks: *flagDebtEscalation,
+		EURRate:         *flagEURRate,
+	}
+	if reminders.Account == "" {
+		reminders.Account = fmt.Sprintf("%d/%s", *flagAccountNumber, bank.CSOB_BANK_CODE)
+	}
+	cronWorker := NewCronWorker(paymentSource, csobClient, ledger, sheetOperator, tymujClient, messageService, directMessageService, imageService, qrRenderer, dbClient, reminders)
 	locationPrague, err := time.LoadLocation("Europe/Prague")
 	if err != nil {
-		log.Printf("Error loading timezone:", err)
+		log.Printf("Error loading timezone: %v", err)
 	}
 	c := cron.NewWithLocation(locationPrague)
 	c.AddFunc("0 */10 * * * *", func() { cronWorker.CheckNewPayments() })
 	c.AddFunc("0 0 9 * * *", func() { cronWorker.CheckUnprocessedPayments() })
 	c.AddFunc("0 0 12 * * 4", func() { cronWorker.CreateWednesdayEventForPlayers() })
 	c.AddFunc("0 0 12 * * 4", func() { cronWorker.CreateWednesdayEventForGoalies() })
+	c.AddFunc("0 30 3 * * *", func() { cronWorker.ExpireProcessedMessages() })
+	c.AddFunc("0 0 18 * * 1", func() { cronWorker.SendDebtReminders() })
 	c.Start()
 	defer c.Stop()
 
-	handler := NewHandler(newRelicApp, imageService, messageService, tymujClient, sheetOperator, driveOperator, *flagBotID, dbClient, csobClient, *flagDeviceDetector)
+	var groupService *groupme.GroupService
+	if *flagVerifyMessages {
+		groupService = groupme.NewGroupService(*flagUserToken)
+	}
+
+	handler := NewHandler(newRelicApp, imageService, messageService, directMessageService, tymujClient, sheetOperator, driveOperator, *flagBotID, dbClient, csobClient, cronWorker, ledger, *flagDeviceDetector, splitList(*flagAdminUserIDs), groupCommands, *flagEURRate, qrRenderer, *flagCallbackToken, groupIDs, groupService, *flagWorkers, *flagQueueSize)
 	fmt.Printf("Starting server...")
 	err = http.ListenAndServe(*flagPort, handler.Mux())
 	if errors.Is(err, http.ErrServerClosed) {
@@ -84,3 +153,13 @@ func main() {
 		log.Printf("Server exited, err: %v", err)
 	}
 }
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
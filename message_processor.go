@@ -2,18 +2,18 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrg/strutil"
 	"github.com/adrg/strutil/metrics"
+	"github.com/vlcak/groupme_qr_bot/bankaccount"
 	database "github.com/vlcak/groupme_qr_bot/db"
 	"github.com/vlcak/groupme_qr_bot/google"
 	"github.com/vlcak/groupme_qr_bot/groupme"
@@ -29,59 +29,95 @@ const (
 )
 
 type GroupmeMessage struct {
-	Attachments []interface{} `json:"attachments"`
-	AvatarUrl   string        `json:"avatar_url"`
-	CreatedAt   int64         `json:"created_at"`
-	GroupId     string        `json:"group_id"`
-	Id          string        `json:"id"`
-	Name        string        `json:"name"`
-	SenderId    string        `json:"sender_id"`
-	SenderType  string        `json:"sender_type"`
-	SourceGuid  string        `json:"source_guid"`
-	System      bool          `json:"system"`
-	Text        string        `json:"text"`
-	UserId      string        `json:"user_id"`
+	Attachments []groupme.Attachment `json:"attachments"`
+	AvatarUrl   string               `json:"avatar_url"`
+	CreatedAt   int64                `json:"created_at"`
+	GroupId     string               `json:"group_id"`
+	Id          string               `json:"id"`
+	Name        string               `json:"name"`
+	SenderId    string               `json:"sender_id"`
+	SenderType  string               `json:"sender_type"`
+	SourceGuid  string               `json:"source_guid"`
+	System      bool                 `json:"system"`
+	Text        string               `json:"text"`
+	UserId      string               `json:"user_id"`
+}
+
+// MentionedUserIDs returns user IDs from the "mentions" attachment
+func (m *GroupmeMessage) MentionedUserIDs() []string {
+	var userIDs []string
+	for _, attachment := range m.Attachments {
+		if attachment.Type == "mentions" {
+			userIDs = append(userIDs, attachment.UserIDs...)
+		}
+	}
+	return userIDs
 }
 
 func NewMessageProcessor(
 	imageService *groupme.ImageService,
 	messageService *groupme.MessageService,
+	directMessageService *groupme.DirectMessageService,
 	tymujClient *tymuj.Client,
 	sheetOperator *google.SheetOperator,
 	driveOperator *google.DriveOperator,
+	ledger *Ledger,
 	selfID string,
 	db *database.Client,
+	adminIDs []string,
+	groupCommands map[string][]string,
+	eurRate float64,
+	qrRenderer *utils.QRRenderer,
 ) *MessageProcessor {
 	m := &MessageProcessor{
-		imageService:     imageService,
-		messageService:   messageService,
-		tymujClient:      tymujClient,
-		sheetOperator:    sheetOperator,
-		driveOperator:    driveOperator,
-		paymentGenerator: utils.NewQRPaymentGenerator(),
-		selfID:           selfID,
-		db:               db,
+		imageService:         imageService,
+		messageService:       messageService,
+		directMessageService: directMessageService,
+		tymujClient:          tymujClient,
+		sheetOperator:        sheetOperator,
+		driveOperator:        driveOperator,
+		ledger:               ledger,
+		pricing:              NewPricingEngine(db),
+		paymentGenerator:     utils.NewQRPaymentGenerator(qrRenderer),
+		selfID:               selfID,
+		db:                   db,
+		adminIDs:             adminIDs,
+		groupCommands:        groupCommands,
+		eurRate:              eurRate,
+		commands:             NewCommandRegistry(),
+		pending:              map[string]*pendingOperation{},
 	}
+	m.registerCommands()
 	return m
 }
 
 type MessageProcessor struct {
-	imageService     *groupme.ImageService
-	messageService   *groupme.MessageService
-	paymentGenerator *utils.QRPaymentGenerator
-	sheetOperator    *google.SheetOperator
-	driveOperator    *google.DriveOperator
-	tymujClient      *tymuj.Client
-	selfID           string
-	db               *database.Client
-}
-
-func (mp *MessageProcessor) ProcessMessage(body io.ReadCloser) error {
-	m := GroupmeMessage{}
-	if err := json.NewDecoder(body).Decode(&m); err != nil {
-		log.Printf("ERROR: %v\n", err)
-		return err
-	}
+	imageService   *groupme.ImageService
+	messageService *groupme.MessageService
+	// directMessageService is nil when personal messages are disabled
+	directMessageService *groupme.DirectMessageService
+	paymentGenerator     *utils.QRPaymentGenerator
+	sheetOperator        *google.SheetOperator
+	driveOperator        *google.DriveOperator
+	ledger               *Ledger
+	pricing              *PricingEngine
+	tymujClient          *tymuj.Client
+	selfID               string
+	db                   *database.Client
+	adminIDs             []string
+	// groupCommands holds allowed commands of groups with restricted command set
+	groupCommands map[string][]string
+	// eurRate is CZK amount of one EUR used for EPC payments
+	eurRate  float64
+	commands *CommandRegistry
+	// jobQueue runs the commands in background, STATUS reports its jobs
+	jobQueue *JobQueue
+	// pending holds operations waiting for CONFIRM by sender ID
+	pending      map[string]*pendingOperation
+	pendingMutex sync.Mutex
+}
+
+func (mp *MessageProcessor) ProcessMessage(m GroupmeMessage) error {
 	// Ignore own messages
 	if m.SenderId == mp.selfID {
 		log.Printf("Ignoring own message\n")
@@ -89,123 +125,412 @@ func (mp *MessageProcessor) ProcessMessage(body io.ReadCloser) error {
 	}
 	log.Printf("Message text: %s ID %s \n", m.Text, m.SenderId)
 
-	parsedMessage := strings.SplitAfterN(m.Text, " ", 4)
-	switch command := strings.TrimSpace(parsedMessage[0]); command {
-	case "QR":
-		if len(parsedMessage) != 4 {
-			log.Printf("Wrong QR format\n")
-			mp.messageService.SendMessage("Wrong QR format", "")
-			return nil
-		}
-		err := mp.createPayment(m.SenderId, strings.TrimSpace(parsedMessage[1]), strings.TrimSpace(parsedMessage[2]), parsedMessage[3])
-		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing QR: %v", err), "")
-		}
-	case "PAY":
-		if len(parsedMessage) < 2 || len(parsedMessage) > 3 {
-			log.Printf("Wrong PAY format\n")
-			mp.messageService.SendMessage("Wrong PAY format", "")
-			return nil
-		}
-		userAmount := ""
-		if len(parsedMessage) == 3 {
-			userAmount = strings.TrimSpace(parsedMessage[2])
-		}
-		err := mp.processEvent(m.SenderId, strings.TrimSpace(parsedMessage[1]), userAmount)
-		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing PAY: %v", err), "")
-		}
-	case "ADD_ACCOUNT":
-		if len(parsedMessage) != 2 {
-			log.Printf("Wrong ADD_ACCOUNT format\n")
-			mp.messageService.SendMessage("Wrong ADD_ACCOUNT format", "")
-			return nil
-		}
-		err := mp.db.SetGroupmeAccount(m.SenderId, strings.TrimSpace(parsedMessage[1]))
-		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing ADD_ACCOUNT: %v", err), "")
-		}
-	case "LINEUP":
-		if len(parsedMessage) < 1 {
-			log.Printf("Wrong LINEUP format\n")
-			mp.messageService.SendMessage("Wrong LINEUP format", "")
-			return nil
-		}
-		err := mp.processLineup(strings.Replace(strings.Join(parsedMessage[1:], " "), "  ", " ", -1))
-		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing LINEUP: %v", err), "")
-		}
-	case "CREATE_GAMES":
-		if len(parsedMessage) != 2 {
-			log.Printf("Wrong CREATE_GAMES format\n")
-			mp.messageService.SendMessage("Wrong CREATE_GAMES format", "")
-			return nil
-		}
-		err := mp.createGames(strings.TrimSpace(parsedMessage[1]))
+	// GroupMe may deliver the same callback more than once, run commands only once
+	command := mp.commandOf(m)
+	if command == nil || m.Id == "" {
+		mp.processMessage(m)
+		return nil
+	}
+	claimed, err := mp.db.ClaimMessage(m.Id, command.Name)
+	if err != nil {
+		log.Printf("Can't claim message %s: %v\n", m.Id, err)
+	} else if !claimed {
+		processed, err := mp.db.GetProcessedMessage(m.Id)
 		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing CREATE_GAMES: %v", err), "")
-		}
-	case "SCHEDULE_EXCEPTION":
-		if len(parsedMessage) < 2 || len(parsedMessage) > 3 {
-			log.Printf("Wrong SCHEDULE_EXCEPTION format\n")
-			mp.messageService.SendMessage("Wrong SCHEDULE_EXCEPTION format", "")
-			return nil
-		}
-		time := ""
-		if len(parsedMessage) == 3 {
-			time = strings.TrimSpace(parsedMessage[2])
+			log.Printf("Can't get processed message %s: %v\n", m.Id, err)
+			return err
 		}
-		err := mp.ScheduleException(strings.TrimSpace(parsedMessage[1]), time)
-		if err != nil {
-			mp.messageService.SendMessage(fmt.Sprintf("Error occured when processing SCHEDULE_EXCEPTION: %v", err), "")
+		log.Printf("Skipping duplicate message %s, command: %s, outcome: %s\n", m.Id, processed.Command.String, processed.Outcome.String)
+		return nil
+	}
+	outcome := mp.processMessage(m)
+	if err == nil {
+		if err := mp.db.StoreMessageOutcome(m.Id, outcome); err != nil {
+			log.Printf("Can't store outcome of message %s: %v\n", m.Id, err)
 		}
-	case "HELP":
-		mp.messageService.SendMessage("Commands:\n"+
-			"QR <amount> <split> <description> - creates QR code for payment\n"+
-			"PAY <amount> ?<perUser> - processes latest event\n"+
-			"ADD_ACCOUNT <account> - adds bank account to groupme account\n"+
-			"LINEUP - creates lineup for next game\n"+
-			"CREATE_GAMES <date> - creates games from given spreadsheet\n"+
-			"SCHEDULE_EXCEPTION <date> ?<time> - unschedule game\n"+
-			"HELP - prints this message", "")
-	default:
-		log.Printf("Not a command\n")
-		mp.messageService.SendMessage(fmt.Sprintf("Not a command: %s", command), "")
 	}
-
 	return nil
 }
 
-func (mp *MessageProcessor) processEvent(senderId, amoutStr, perUserAmount string) error {
-	events, err := mp.tymujClient.GetEvents(true, false, true, false)
+// processMessage runs the command and returns a short description of the outcome
+func (mp *MessageProcessor) processMessage(m GroupmeMessage) string {
+	ms := mp.messageService.ForGroup(m.GroupId)
+	message, err := utils.Tokenize(m.Text)
 	if err != nil {
-		log.Printf("Unable to get events: %v\n", err)
-		return err
+		log.Printf("Can't parse message: %v\n", err)
+		reply(ms, fmt.Sprintf("Can't parse message: %v", err))
+		return fmt.Sprintf("parse error: %v", err)
 	}
-	lastEvent := events[0]
-	log.Printf("Last event: %v", lastEvent)
+	if len(message.Tokens) == 0 {
+		log.Printf("Not a command\n")
+		return "not a command"
+	}
+	commandToken := message.Tokens[0]
+	command, ok := mp.commands.Lookup(commandToken.Value)
+	if !ok {
+		log.Printf("Not a command\n")
+		reply(ms, fmt.Sprintf("Not a command: %s", commandToken.Value))
+		return "not a command"
+	}
+	args, err := command.Parse(message, commandToken)
+	if err != nil {
+		log.Printf("Wrong %s format: %v\n", command.Name, err)
+		reply(ms, fmt.Sprintf("Wrong %s format: %v\nUsage: %s", command.Name, err, command.Usage()))
+		return fmt.Sprintf("wrong format: %v", err)
+	}
+	if commands, ok := mp.groupCommands[m.GroupId]; ok && !slices.Contains(commands, command.Name) {
+		log.Printf("%s not allowed in group %s\n", command.Name, m.GroupId)
+		reply(ms, fmt.Sprintf("%s is not available in this group", command.Name))
+		return "not allowed in group"
+	}
+	if allowed, err := mp.authorize(m, command); err != nil {
+		log.Printf("Can't check role of %s: %v\n", m.SenderId, err)
+		reply(ms, fmt.Sprintf("Can't check your role: %v", err))
+		return fmt.Sprintf("role error: %v", err)
+	} else if !allowed {
+		log.Printf("%s not allowed for %s\n", command.Name, m.SenderId)
+		reply(ms, fmt.Sprintf("Not allowed: %s requires %s role", command.Name, command.Role))
+		return "not allowed"
+	}
+	if err := command.Handler(m, args, ms); err != nil {
+		reply(ms, fmt.Sprintf("Error occured when processing %s: %v", command.Name, err))
+		return fmt.Sprintf("error: %v", err)
+	}
+	return "ok"
+}
 
-	tymujAtendees, err := mp.tymujClient.GetAtendees(lastEvent.Id, true, []int{GOALIES_GROUP_ID})
+// reply sends the text to the group, failures are only logged as there is
+// nowhere else to report them
+func reply(ms *groupme.MessageService, text string) {
+	if err := ms.SendMessage(text, ""); err != nil {
+		log.Printf("Can't send message: %v\n", err)
+	}
+}
+
+// commandOf returns the command the message invokes or nil
+func (mp *MessageProcessor) commandOf(m GroupmeMessage) *Command {
+	message, err := utils.Tokenize(m.Text)
+	if err != nil || len(message.Tokens) == 0 {
+		return nil
+	}
+	command, ok := mp.commands.Lookup(message.Tokens[0].Value)
+	if !ok {
+		return nil
+	}
+	return command
+}
+
+func (mp *MessageProcessor) registerCommands() {
+	mp.commands.Register(&Command{
+		Name: "QR",
+		Args: []Argument{
+			{Name: "amount", Type: ARG_AMOUNT},
+			{Name: "split", Type: ARG_INT},
+			{Name: "description", Type: ARG_TEXT},
+		},
+		Description: "creates QR code for payment",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.createPayment(ms, m.SenderId, m.Name, args.Amount("amount"), args.Int("split"), args.String("description"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "PAY",
+		Args: []Argument{
+			{Name: "amount", Type: ARG_INT},
+			{Name: "perUser", Type: ARG_INT, Optional: true},
+			{Name: "dry-run", Type: ARG_FLAG},
+		},
+		Description:   "processes latest event, with --dry-run only previews it until CONFIRM",
+		Role:          database.ROLE_TREASURER,
+		MaxConcurrent: 1,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.processEvent(ms, m.SenderId, m.Name, args.Int("amount"), args.Int("perUser"), args.Bool("dry-run"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "ADJUST",
+		Args: []Argument{
+			{Name: "amount", Type: ARG_INT},
+			{Name: "name", Type: ARG_TEXT},
+			{Name: "note", Type: ARG_WORD, Optional: true},
+		},
+		Description: "adds amount (negative to subtract) to balance of the player, --note=\"reason\"",
+		Role:        database.ROLE_TREASURER,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.adjustBalance(ms, m.Name, strings.Join(strings.Fields(args.String("name")), " "), args.Int("amount"), args.String("note"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "BALANCE",
+		Args: []Argument{
+			{Name: "name", Type: ARG_TEXT, Optional: true},
+		},
+		Description: "prints your balance, treasurer can check balance of any player",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.sendBalance(ms, m.SenderId, strings.Join(strings.Fields(args.String("name")), " "))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:        "BALANCES",
+		Description: "prints balances of the whole team sorted by debt",
+		Role:        database.ROLE_TREASURER,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.sendBalances(ms)
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "STATEMENT",
+		Args: []Argument{
+			{Name: "month", Type: ARG_WORD, Optional: true},
+		},
+		Description: "lists your payments and charged events in the month (M, M/YYYY or YYYY-MM), current month by default",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.sendStatement(ms, m.SenderId, args.String("month"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "REMINDERS",
+		Args: []Argument{
+			{Name: "state", Type: ARG_WORD},
+		},
+		Description: "turns your weekly debt reminders on or off",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.setReminders(ms, m.SenderId, args.String("state"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:          "LEDGER_INIT",
+		Description:   "imports balances from the sheet as opening balances of the empty ledger",
+		Role:          database.ROLE_ADMIN,
+		MaxConcurrent: 1,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.ledger.initFromSheet(ms, m.Name)
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:          "CONFIRM",
+		Description:   "commits your pending operation, e.g. PAY --dry-run, pending operations are lost when the bot restarts",
+		MaxConcurrent: 1,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.confirmPending(ms, m.SenderId)
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:        "CANCEL",
+		Description: "discards your pending operation",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.cancelPending(ms, m.SenderId)
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "PRICE",
+		Args: []Argument{
+			{Name: "amount", Type: ARG_INT, Optional: true},
+			{Name: "perUser", Type: ARG_INT, Optional: true},
+		},
+		Description: "previews price of the latest event for PAY with the same arguments",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.previewPrice(ms, args.Int("amount"), args.Int("perUser"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:        "PRICE_RULES",
+		Description: "lists pricing rules",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.listPricingRules(ms)
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "PRICE_RULE_ADD",
+		Args: []Argument{
+			{Name: "mode", Type: ARG_WORD},
+			{Name: "amount", Type: ARG_INT},
+			{Name: "type", Type: ARG_WORD, Optional: true},
+			{Name: "location", Type: ARG_WORD, Optional: true},
+			{Name: "weekday", Type: ARG_WORD, Optional: true},
+			{Name: "category", Type: ARG_WORD, Optional: true},
+		},
+		Description: "adds pricing rule, mode fixed/split or discount/surcharge for --category=goalie/junior/guest, limited by --type=game/practice, --location, --weekday=mon..sun",
+		Role:        database.ROLE_TREASURER,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.addPricingRule(ms, args.String("mode"), args.Int("amount"), args.String("type"), args.String("location"), args.String("weekday"), args.String("category"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "PRICE_RULE_DELETE",
+		Args: []Argument{
+			{Name: "id", Type: ARG_INT},
+		},
+		Description: "deletes pricing rule",
+		Role:        database.ROLE_TREASURER,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.deletePricingRule(ms, args.Int("id"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "ADD_ACCOUNT",
+		Args: []Argument{
+			{Name: "account", Type: ARG_WORD},
+		},
+		Description: "adds bank account (prefix-number/bank or IBAN) to groupme account",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.addAccount(ms, m.SenderId, args.String("account"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "QR_FORMAT",
+		Args: []Argument{
+			{Name: "format", Type: ARG_WORD},
+		},
+		Description: "sets QR payment format you can scan - spd (Czech banks) or epc (SEPA, EUR)",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.setPaymentFormat(ms, m.SenderId, args.String("format"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "LINK_PLAYER",
+		Args: []Argument{
+			{Name: "name", Type: ARG_TEXT},
+		},
+		Description: "links your groupme account to player, so you get mentioned",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.linkPlayer(ms, m.SenderId, strings.Join(strings.Fields(args.String("name")), " "))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "LINEUP",
+		Args: []Argument{
+			{Name: "captain", Type: ARG_TEXT, Optional: true},
+		},
+		Description:   "creates lineup for next game",
+		Role:          database.ROLE_CAPTAIN,
+		MaxConcurrent: 1,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.processLineup(ms, strings.Join(strings.Fields(args.String("captain")), " "))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "CREATE_GAMES",
+		Args: []Argument{
+			{Name: "sheet", Type: ARG_WORD},
+		},
+		Description:   "creates games from given spreadsheet",
+		Role:          database.ROLE_CAPTAIN,
+		MaxConcurrent: 1,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.createGames(ms, args.String("sheet"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "SCHEDULE_EXCEPTION",
+		Args: []Argument{
+			{Name: "date", Type: ARG_DATE},
+			{Name: "time", Type: ARG_TIME, Optional: true},
+		},
+		Description: "unschedule game",
+		Role:        database.ROLE_CAPTAIN,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.ScheduleException(ms, args.String("date"), args.String("time"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "ADMIN_GRANT",
+		Args: []Argument{
+			{Name: "role", Type: ARG_WORD},
+			{Name: "user", Type: ARG_TEXT},
+		},
+		Description: "grants admin/treasurer/captain/member role to @user",
+		Role:        database.ROLE_ADMIN,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.grantRole(ms, m, args.String("role"), args.String("user"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name: "ADMIN_REVOKE",
+		Args: []Argument{
+			{Name: "user", Type: ARG_TEXT},
+		},
+		Description: "revokes role of @user",
+		Role:        database.ROLE_ADMIN,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return mp.revokeRole(ms, m, args.String("user"))
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:        "HELP",
+		Description: "prints this message",
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			return ms.SendMessage(mp.commands.Help(), "")
+		},
+	})
+	mp.commands.Register(&Command{
+		Name:        "STATUS",
+		Description: "lists running commands",
+		Immediate:   true,
+		Handler: func(m GroupmeMessage, args CommandArgs, ms *groupme.MessageService) error {
+			if mp.jobQueue == nil {
+				return ms.SendMessage("Nothing running", "")
+			}
+			return ms.SendMessage(mp.jobQueue.Status(), "")
+		},
+	})
+}
+
+// eventPayment is PAY of the latest event prepared to be charged
+type eventPayment struct {
+	event         tymuj.Event
+	senderId      string
+	senderName    string
+	accountNumber string
+	// message is the sanitized payment message, warning is not empty when it
+	// had to be altered
+	message string
+	warning string
+	amount  int
+	matched attendance
+	quote   *PriceQuote
+}
+
+func (mp *MessageProcessor) processEvent(ms *groupme.MessageService, senderId, senderName string, amount, perUserAmount int, dryRun bool) error {
+	payment, err := mp.prepareEventPayment(ms, senderId, senderName, amount, perUserAmount)
 	if err != nil {
-		log.Printf("Unable to get atendees: %v\n", err)
 		return err
 	}
-	var atendees []string
-	for _, a := range tymujAtendees {
-		atendees = append(atendees, utils.Normalize(a.Name))
+	if !dryRun {
+		return mp.chargeEventPayment(ms, payment)
+	}
+	mp.setPending(senderId, fmt.Sprintf("PAY %s", payment.message), func(ms *groupme.MessageService) error {
+		// attendance or prices may have changed since the preview
+		current, err := mp.prepareEventPayment(ms, senderId, senderName, amount, perUserAmount)
+		if err != nil {
+			return err
+		}
+		if !current.sameAs(payment) {
+			log.Printf("PAY %s changed since the preview\n", payment.message)
+			return errors.New("the event changed since the preview, run PAY --dry-run again")
+		}
+		return mp.chargeEventPayment(ms, current)
+	})
+	return ms.SendMessage(payment.preview(), "")
+}
+
+// prepareEventPayment matches attendees of the latest event to the players
+// and prices the event, nothing is charged yet
+func (mp *MessageProcessor) prepareEventPayment(ms *groupme.MessageService, senderId, senderName string, amount, perUserAmount int) (*eventPayment, error) {
+	// nothing is sent until the charges can be stored
+	if err := mp.ledger.CheckInitialized(); err != nil {
+		return nil, err
+	}
+	lastEvent, atendees, err := mp.lastEventAtendees()
+	if err != nil {
+		return nil, err
 	}
 
 	accountNumber, err := mp.db.GetGroupmeAccount(senderId)
 	if err != nil || accountNumber == "" {
 		log.Printf("Unknown sender\n")
-		mp.messageService.SendMessage("I don't know your account", "")
-		return errors.New("unknown sender")
-	}
-
-	amount, err := strconv.Atoi(amoutStr)
-	if err != nil {
-		log.Printf("Cant parse amount %v\n", err)
-		return err
+		reply(ms, "I don't know your account")
+		return nil, errors.New("unknown sender")
 	}
 
 	eventName := "hokej"
@@ -213,20 +538,72 @@ func (mp *MessageProcessor) processEvent(senderId, amoutStr, perUserAmount strin
 		eventName = "zapas"
 	}
 	message := fmt.Sprintf("%s %s", eventName, lastEvent.StartTime.Format("2.1."))
-	// split := len(atendees)
-	// amountSplitted := (amount + split - 1) / split
-	amountSplitted := 250
-	if perUserAmount != "" {
-		amountSplitted, err = strconv.Atoi(perUserAmount)
-		if err != nil {
-			log.Printf("Cant parse per user amount %v\n", err)
-			return err
+	players, err := mp.db.GetPlayers()
+	if err != nil {
+		log.Printf("Can't get players %v\n", err)
+		return nil, err
+	}
+
+	matched := matchAttendees(players, atendees)
+
+	quote, err := mp.pricing.Quote(lastEvent, amount, perUserAmount, matched.payers())
+	if err != nil {
+		log.Printf("Can't price the event %v\n", err)
+		return nil, err
+	}
+
+	message, warning := mp.paymentMessage(message)
+	return &eventPayment{
+		event:         lastEvent,
+		senderId:      senderId,
+		senderName:    senderName,
+		accountNumber: accountNumber,
+		message:       message,
+		warning:       warning,
+		amount:        amount,
+		matched:       matched,
+		quote:         quote,
+	}, nil
+}
+
+// preview describes what charging the payment would do
+func (p *eventPayment) preview() string {
+	preview := fmt.Sprintf("PAY dry run: %s, base price: %d (%s)\nAssignments:\n", p.message, p.quote.Base, p.quote.BaseRule)
+	row := []string{p.message}
+	for i, name := range p.matched.charged {
+		category := p.matched.categories[name]
+		price := p.quote.Price(category)
+		if category != "" {
+			category = " " + category
 		}
-	} else if lastEvent.IsGame || lastEvent.Capacity > 12 {
-		amountSplitted = 300
+		preview += fmt.Sprintf("%s <- %s (%.2f) %d%s\n", name, p.matched.processed[i], p.matched.scores[i], price, category)
+		if price != 0 {
+			row = append(row, fmt.Sprintf("%s -%d", name, price))
+		}
+	}
+	if len(p.matched.charged) == 0 {
+		preview += "none\n"
 	}
+	guestPrice := p.quote.Price(database.CATEGORY_GUEST)
+	preview += fmt.Sprintf("Hosts (%d each): %s\n", guestPrice, strings.Join(p.matched.hosts, ","))
+	if len(p.matched.hosts) > 0 && guestPrice > 0 {
+		row = append(row, fmt.Sprintf("%s -%d", google.HOSTS, guestPrice*len(p.matched.hosts)))
+	}
+	preview += fmt.Sprintf("Row: %s\n", strings.Join(row, " | "))
+	preview += fmt.Sprintf("Reply CONFIRM to charge or CANCEL to discard, expires in %v or when the bot restarts", PENDING_OPERATION_TTL)
+	return preview
+}
+
+// sameAs reports whether the payment charges the same event, players and
+// prices as the other one
+func (p *eventPayment) sameAs(other *eventPayment) bool {
+	return p.event.Id == other.event.Id && p.preview() == other.preview()
+}
 
-	image, err := mp.paymentGenerator.Generate(message, accountNumber, strconv.Itoa(amountSplitted))
+// chargeEventPayment charges the attendees, regenerates the sheet and sends
+// the payment QR codes
+func (mp *MessageProcessor) chargeEventPayment(ms *groupme.MessageService, p *eventPayment) error {
+	image, amountDescription, err := mp.paymentQR(p.senderId, p.senderName, p.message, "", p.accountNumber, float64(p.quote.Base))
 	if err != nil {
 		log.Printf("Error generating QR %v\n", err)
 		return err
@@ -236,114 +613,346 @@ func (mp *MessageProcessor) processEvent(senderId, amoutStr, perUserAmount strin
 		log.Printf("Error during image upload %v\n", err)
 		return err
 	}
-	mp.messageService.SendMessage(fmt.Sprintf("Here is the payment QR for %d, msg: %s:", amountSplitted, message), imageURL)
-
-	originalSheetNames, err := mp.sheetOperator.Get("Sheet1!D1:1", "", true)
-	if err != nil {
-		log.Printf("Can't get sheet names %v\n", err)
+	// nothing is charged when the QR can't be sent
+	if err := ms.SendMessage(fmt.Sprintf("Here is the payment QR for %s, msg: %s:%s", amountDescription, p.message, p.warning), imageURL); err != nil {
+		log.Printf("Can't send payment QR %v\n", err)
 		return err
 	}
-	// remove hosts & normalize
-	sheetNames := make([]string, len(originalSheetNames)-1)
-	copy(sheetNames, originalSheetNames)
-	utils.NormalizeArray(sheetNames)
-	remainings, err := mp.sheetOperator.Get("Sheet1!D3:3", "", true)
+
+	balances, err := mp.ledger.Balances()
 	if err != nil {
-		log.Printf("Can't get sheet remainings %v\n", err)
+		log.Printf("Can't get balances %v\n", err)
 		return err
 	}
-	var sufficient, insufficient, insufficientDept []string
 
-	row := []interface{}{message, amount, amountSplitted}
-	var processed []string
-	lev := metrics.NewLevenshtein()
-	for i, name := range sheetNames {
-		pos := slices.IndexFunc(atendees, func(aName string) bool {
-			return strutil.Similarity(aName, name, lev) > 0.75
-		})
-		if pos != -1 {
-			log.Printf("ASSIGNED: %s:%s, val: %f\n", name, atendees[pos], strutil.Similarity(atendees[pos], name, lev))
-			processed = append(processed, atendees[pos])
-			atendees = append(atendees[:pos], atendees[pos+1:]...)
-			row = append(row, "1")
-			rem, err := strconv.Atoi(remainings[i])
-			if err != nil {
-				log.Printf("Can't parse %s to int %v\n", remainings[i], err)
-				continue
-			}
-			if rem >= amountSplitted {
-				sufficient = append(sufficient, originalSheetNames[i])
-			} else {
-				insufficient = append(insufficient, originalSheetNames[i])
-				insufficientDept = append(insufficientDept, strconv.Itoa(amountSplitted-rem))
-			}
+	log.Printf("Charging %s, total: %d, per player: %d (%s)\n", p.message, p.amount, p.quote.Base, p.quote.BaseRule)
+	var sufficient, insufficient []string
+	var insufficientDept []int
+	// players of the same price are charged together
+	var prices []int
+	byPrice := map[int][]string{}
+	for _, name := range p.matched.charged {
+		price := p.quote.Price(p.matched.categories[name])
+		if price == 0 {
+			continue
+		}
+		if _, ok := byPrice[price]; !ok {
+			prices = append(prices, price)
+		}
+		byPrice[price] = append(byPrice[price], name)
+		if rem := balances[name]; rem >= price {
+			sufficient = append(sufficient, name)
 		} else {
-			row = append(row, "")
+			insufficient = append(insufficient, name)
+			insufficientDept = append(insufficientDept, price-rem)
 		}
 	}
-	// the rest are hosts
-	if len(atendees) > 0 {
-		row = append(row, len(atendees))
-		row = append(row, strings.Join(atendees, ","))
-		insufficient = append(insufficient, atendees...)
+	for _, price := range prices {
+		err = mp.ledger.Charge(byPrice[price], price, p.message, p.senderName)
+		if err != nil {
+			log.Printf("Can't charge players %v\n", err)
+			return err
+		}
 	}
-	err = mp.sheetOperator.AppendLine("Sheet1", row)
-	if err != nil {
-		log.Printf("Can't insert row %v\n", err)
+	// hosts share one column
+	guestPrice := p.quote.Price(database.CATEGORY_GUEST)
+	if len(p.matched.hosts) > 0 && guestPrice > 0 {
+		err = mp.ledger.Charge([]string{google.HOSTS}, guestPrice*len(p.matched.hosts), fmt.Sprintf("%s (%s)", p.message, strings.Join(p.matched.hosts, ",")), p.senderName)
+		if err != nil {
+			log.Printf("Can't charge hosts %v\n", err)
+			return err
+		}
+		for _, name := range p.matched.hosts {
+			insufficient = append(insufficient, name)
+			insufficientDept = append(insufficientDept, guestPrice)
+		}
+	}
+	if err = mp.ledger.Project(); err != nil {
+		log.Printf("Can't regenerate the sheet %v\n", err)
 		return err
 	}
 
-	mp.messageService.SendMessage(
+	// the players are charged already, so failed messages must not look like
+	// a failed PAY which would be run again
+	err = ms.SendMessage(
 		fmt.Sprintf(
 			"Processed %d atendees, hosts: %s\nBalance OK: %d, BAD: %d:",
-			len(processed),
-			strings.Join(atendees, ","),
+			len(p.matched.processed),
+			strings.Join(p.matched.hosts, ","),
 			len(sufficient),
 			len(insufficient)),
 		"")
-	mp.messageService.SendMessage(
+	if err != nil {
+		log.Printf("Can't send charge summary %v\n", err)
+		return fmt.Errorf("players were charged, but the summary wasn't sent: %w", err)
+	}
+	// linked players get their QR privately, the rest is listed in the group
+	var groupDebtors []string
+	var groupDebts []int
+	var sentPrivately []string
+	for i, name := range insufficient {
+		debt := insufficientDept[i]
+		if sent, err := mp.sendPersonalPayment(name, p.senderName, debt, p.message, p.accountNumber); err != nil {
+			log.Printf("Can't send personal payment to %s: %v\n", name, err)
+		} else if sent {
+			sentPrivately = append(sentPrivately, name)
+			continue
+		}
+		groupDebtors = append(groupDebtors, name)
+		groupDebts = append(groupDebts, debt)
+	}
+	if len(sentPrivately) > 0 {
+		err = ms.SendMessage(
+			fmt.Sprintf(
+				"Payment QR sent privately to: %s",
+				strings.Join(sentPrivately, ",")),
+			"")
+		if err != nil {
+			log.Printf("Can't send list of private payments %v\n", err)
+			return fmt.Errorf("players were charged, but the list of private payments wasn't sent: %w", err)
+		}
+	}
+	if len(groupDebtors) == 0 {
+		return nil
+	}
+
+	err = ms.SendMessage(
 		fmt.Sprintf(
 			"Platba pro: %s",
-			strings.Join(insufficient, ",")),
+			strings.Join(groupDebtors, ",")),
 		"")
+	if err != nil {
+		log.Printf("Can't send debtors %v\n", err)
+		return fmt.Errorf("players were charged, but the debtors weren't sent: %w", err)
+	}
 	messageWithRemainig := "Platba pro: \n"
-	for i, name := range insufficient {
-		if i >= len(insufficientDept) {
-			messageWithRemainig += fmt.Sprintf("%s(%d)\n", name, amountSplitted)
-		} else {
-			messageWithRemainig += fmt.Sprintf("%s(%s)\n", name, insufficientDept[i])
-		}
+	var mentions []groupme.Mention
+	for i, name := range groupDebtors {
+		messageWithRemainig += fmt.Sprintf("%s(%d)\n", mp.mention(name, &mentions), groupDebts[i])
 	}
-	mp.messageService.SendMessage(
+	err = ms.SendMessageWithMentions(
 		messageWithRemainig,
 		"",
+		mentions,
 	)
+	if err != nil {
+		log.Printf("Can't send debts %v\n", err)
+		return fmt.Errorf("players were charged, but the debts weren't sent: %w", err)
+	}
 	return nil
 }
 
-func (mp *MessageProcessor) createPayment(senderId, amoutStr, splitStr, message string) error {
-	accountNumber, err := mp.db.GetGroupmeAccount(senderId)
-	if err != nil || accountNumber == "" {
-		log.Printf("Unknown sender\n")
-		mp.messageService.SendMessage("I don't know your account", "")
-		return errors.New("unknown sender")
+// lastEventAtendees returns the latest past event with normalized names of
+// its attendees, goalies are not included
+func (mp *MessageProcessor) lastEventAtendees() (tymuj.Event, []string, error) {
+	events, err := mp.tymujClient.GetEvents(true, false, true, false)
+	if err != nil {
+		log.Printf("Unable to get events: %v\n", err)
+		return tymuj.Event{}, nil, err
+	}
+	if len(events) == 0 {
+		return tymuj.Event{}, nil, errors.New("no past events")
 	}
+	lastEvent := events[0]
+	log.Printf("Last event: %v", lastEvent)
 
-	amount, err := strconv.Atoi(amoutStr)
+	tymujAtendees, err := mp.tymujClient.GetAtendees(lastEvent.Id, true, []int{GOALIES_GROUP_ID})
 	if err != nil {
-		log.Printf("Cant parse amount %v\n", err)
+		log.Printf("Unable to get atendees: %v\n", err)
+		return lastEvent, nil, err
+	}
+	var atendees []string
+	for _, a := range tymujAtendees {
+		atendees = append(atendees, utils.Normalize(a.Name))
+	}
+	return lastEvent, atendees, nil
+}
+
+// attendance holds attendees of the event matched to the players
+type attendance struct {
+	// processed are names of the matched attendees
+	processed []string
+	// charged are names of the matched players
+	charged []string
+	// scores are similarities of the matched names
+	scores     []float64
+	categories map[string]string
+	// hosts are attendees not matched to any player
+	hosts []string
+}
+
+// payers counts the attendees who pay, goalies don't
+func (a *attendance) payers() int {
+	payers := len(a.hosts)
+	for _, name := range a.charged {
+		if a.categories[name] != database.GOALIE {
+			payers++
+		}
+	}
+	return payers
+}
+
+// matchAttendees assigns normalized attendee names to the most similar
+// players, the unassigned attendees are hosts
+func matchAttendees(players []database.Player, atendees []string) attendance {
+	matched := attendance{categories: map[string]string{}}
+	atendees = slices.Clone(atendees)
+	lev := metrics.NewLevenshtein()
+	for _, player := range players {
+		name := utils.Normalize(player.Name.String)
+		pos := slices.IndexFunc(atendees, func(aName string) bool {
+			return strutil.Similarity(aName, name, lev) > 0.75
+		})
+		if pos != -1 {
+			score := strutil.Similarity(atendees[pos], name, lev)
+			log.Printf("ASSIGNED: %s:%s, val: %f\n", name, atendees[pos], score)
+			matched.processed = append(matched.processed, atendees[pos])
+			matched.scores = append(matched.scores, score)
+			atendees = append(atendees[:pos], atendees[pos+1:]...)
+			matched.charged = append(matched.charged, player.Name.String)
+			matched.categories[player.Name.String] = playerCategory(player)
+		}
+	}
+	matched.hosts = atendees
+	return matched
+}
+
+// sendPersonalPayment sends the player a QR code for the owed amount via
+// direct message, it returns false when the player has no linked GroupMe user
+func (mp *MessageProcessor) sendPersonalPayment(name, recipientName string, amount int, message, accountNumber string) (bool, error) {
+	if mp.directMessageService == nil {
+		return false, nil
+	}
+	userID, err := mp.db.GetGroupmeUserID(name)
+	if err != nil || userID == "" {
+		return false, err
+	}
+	variableSymbol := ""
+	if player, err := mp.db.GetPlayerByName(name); err == nil && player.Id.Valid {
+		variableSymbol = database.VariableSymbol(player.Id.Int64)
+	}
+	image, amountDescription, err := mp.paymentQR(userID, recipientName, message, variableSymbol, accountNumber, float64(amount))
+	if err != nil {
+		log.Printf("Error generating QR %v\n", err)
+		return false, err
+	}
+	imageURL, err := mp.imageService.Upload(image)
+	if err != nil {
+		log.Printf("Error during image upload %v\n", err)
+		return false, err
+	}
+	err = mp.directMessageService.SendMessage(userID, fmt.Sprintf("Platba za %s: %s", message, amountDescription), imageURL)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mention returns "@name" and records the mention when the player is linked
+// to a GroupMe user, otherwise the plain name
+func (mp *MessageProcessor) mention(name string, mentions *[]groupme.Mention) string {
+	return mentionPlayer(mp.db, name, mentions)
+}
+
+func mentionPlayer(db *database.Client, name string, mentions *[]groupme.Mention) string {
+	userID, err := db.GetGroupmeUserID(name)
+	if err != nil || userID == "" {
+		return name
+	}
+	text := "@" + name
+	*mentions = append(*mentions, groupme.Mention{
+		UserID: userID,
+		Text:   text,
+	})
+	return text
+}
+
+func (mp *MessageProcessor) adjustBalance(ms *groupme.MessageService, senderName, name string, amount int, note string) error {
+	player, err := mp.db.GetPlayerByName(name)
+	if err != nil || !player.Id.Valid {
+		log.Printf("Unable to get player: %s, err:%v\n", name, err)
+		return fmt.Errorf("unknown player: %s", name)
+	}
+	if amount == 0 {
+		return errors.New("amount can't be zero")
+	}
+	if note == "" {
+		note = "adjustment"
+	}
+	if err := mp.ledger.Adjust(player.Name.String, amount, note, senderName); err != nil {
+		log.Printf("Unable to adjust balance: %v\n", err)
+		return err
+	}
+	if err := mp.ledger.Project(); err != nil {
+		log.Printf("Can't regenerate the sheet %v\n", err)
 		return err
 	}
+	return ms.SendMessage(fmt.Sprintf("Balance of %s adjusted by %d: %s", player.Name.String, amount, note), "")
+}
 
-	split, err := strconv.Atoi(splitStr)
+func (mp *MessageProcessor) addAccount(ms *groupme.MessageService, senderId, account string) error {
+	normalized, err := bankaccount.Normalize(account)
 	if err != nil {
-		log.Printf("Cant parse split %v\n", err)
+		log.Printf("Invalid account %s: %v\n", account, err)
+		return err
+	}
+	if err := mp.db.SetGroupmeAccount(senderId, normalized); err != nil {
+		log.Printf("Unable to store account: %v\n", err)
 		return err
 	}
+	warning := ""
+	if a, err := bankaccount.Parse(normalized); err == nil && !a.KnownBank() {
+		warning = fmt.Sprintf("\nWarning: unknown bank code %s, check the account", a.BankCode)
+	}
+	return ms.SendMessage(fmt.Sprintf("Account stored: %s%s", normalized, warning), "")
+}
 
-	amountSplitted := strconv.Itoa((amount + split - 1) / split)
+func (mp *MessageProcessor) linkPlayer(ms *groupme.MessageService, senderId, name string) error {
+	player, err := mp.db.GetPlayerByName(name)
+	if err != nil || !player.Id.Valid {
+		log.Printf("Unable to get player: %s, err:%v\n", name, err)
+		return fmt.Errorf("unknown player: %s", name)
+	}
+	// player linked to somebody else can be taken over only by treasurer
+	linkedUser, err := mp.db.GetGroupmeUserID(player.Name.String)
+	if err != nil {
+		log.Printf("Unable to get linked user of %s: %v\n", player.Name.String, err)
+		return err
+	}
+	if linkedUser != "" && linkedUser != senderId {
+		role, err := mp.senderRole(senderId)
+		if err != nil {
+			log.Printf("Can't check role of %s: %v\n", senderId, err)
+			return err
+		}
+		if roleLevels[role] < roleLevels[database.ROLE_TREASURER] {
+			return fmt.Errorf("player %s is already linked to another user, ask %s to relink", player.Name.String, database.ROLE_TREASURER)
+		}
+	}
+	if err := mp.db.SetGroupmeUser(senderId, player.Id.Int64); err != nil {
+		log.Printf("Unable to link player: %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Linked to player: %s", player.Name.String), "")
+}
 
-	image, err := mp.paymentGenerator.Generate(message, accountNumber, amountSplitted)
+func (mp *MessageProcessor) createPayment(ms *groupme.MessageService, senderId, senderName string, amount float64, split int, message string) error {
+	accountNumber, err := mp.db.GetGroupmeAccount(senderId)
+	if err != nil || accountNumber == "" {
+		log.Printf("Unknown sender\n")
+		reply(ms, "I don't know your account")
+		return errors.New("unknown sender")
+	}
+
+	if split <= 0 {
+		log.Printf("Invalid split %d\n", split)
+		return errors.New("split must be positive")
+	}
+
+	amountSplitted := math.Ceil(amount*100/float64(split)) / 100
+
+	message, warning := mp.paymentMessage(message)
+	image, amountDescription, err := mp.paymentQR(senderId, senderName, message, "", accountNumber, amountSplitted)
 	if err != nil {
 		log.Printf("Error generating QR %v\n", err)
 		return err
@@ -353,11 +962,72 @@ func (mp *MessageProcessor) createPayment(senderId, amoutStr, splitStr, message
 		log.Printf("Error during image upload %v\n", err)
 		return err
 	}
-	mp.messageService.SendMessage(fmt.Sprintf("Here is the payment QR for %s, msg: %s:", amountSplitted, message), imageURL)
-	return nil
+	return ms.SendMessage(fmt.Sprintf("Here is the payment QR for %s, msg: %s:%s", amountDescription, message, warning), imageURL)
+}
+
+// paymentMessage makes the message safe for payment QR codes, the returned
+// warning is empty when the message didn't have to be altered
+func (mp *MessageProcessor) paymentMessage(message string) (string, string) {
+	sanitized, altered := mp.paymentGenerator.SanitizeMessage(message)
+	if !altered {
+		return sanitized, ""
+	}
+	log.Printf("Payment message altered: %q -> %q\n", message, sanitized)
+	return sanitized, fmt.Sprintf("\nWarning: message was altered to fit the payment, original: %s", message)
+}
+
+func (mp *MessageProcessor) paymentQR(userID, recipientName, message, variableSymbol, accountNumber string, amount float64) ([]byte, string, error) {
+	return paymentQR(mp.db, mp.paymentGenerator, mp.eurRate, userID, recipientName, message, variableSymbol, accountNumber, amount)
+}
+
+// paymentQR generates payment QR code in the format preferred by the user
+// who is going to scan it, for EPC the amount is converted to EUR and the
+// variable symbol is put to the text. It returns the image and the amount
+// description.
+func paymentQR(db *database.Client, generator *utils.QRPaymentGenerator, eurRate float64, userID, recipientName, message, variableSymbol, accountNumber string, amount float64) ([]byte, string, error) {
+	format := database.PAYMENT_FORMAT_SPD
+	if userID != "" {
+		var err error
+		if format, err = db.GetPaymentFormat(userID); err != nil {
+			log.Printf("Can't get payment format of %s: %v\n", userID, err)
+			format = database.PAYMENT_FORMAT_SPD
+		}
+	}
+	if format != database.PAYMENT_FORMAT_EPC {
+		image, err := generator.GenerateSPD(utils.SPDPayment{
+			Account:        accountNumber,
+			Amount:         amount,
+			Message:        message,
+			VariableSymbol: variableSymbol,
+		})
+		return image, fmt.Sprintf("%s Kč", utils.FormatAmount(amount)), err
+	}
+	amountEUR := math.Ceil(amount*100/eurRate) / 100
+	if variableSymbol != "" {
+		message = fmt.Sprintf("VS%s %s", variableSymbol, message)
+	}
+	image, err := generator.GenerateEPC(utils.EPCPayment{
+		Name:   recipientName,
+		IBAN:   accountNumber,
+		Amount: amountEUR,
+		Text:   message,
+	})
+	return image, fmt.Sprintf("%.2f EUR", amountEUR), err
 }
 
-func (mp *MessageProcessor) processLineup(captain string) error {
+func (mp *MessageProcessor) setPaymentFormat(ms *groupme.MessageService, senderId, format string) error {
+	format = strings.ToLower(format)
+	if format != database.PAYMENT_FORMAT_SPD && format != database.PAYMENT_FORMAT_EPC {
+		return fmt.Errorf("unknown format: %s, use %s or %s", format, database.PAYMENT_FORMAT_SPD, database.PAYMENT_FORMAT_EPC)
+	}
+	if err := mp.db.SetPaymentFormat(senderId, format); err != nil {
+		log.Printf("Unable to store payment format: %v\n", err)
+		return err
+	}
+	return ms.SendMessage(fmt.Sprintf("Payment QR format set to %s", strings.ToUpper(format)), "")
+}
+
+func (mp *MessageProcessor) processLineup(ms *groupme.MessageService, captain string) error {
 	events, err := mp.tymujClient.GetEvents(false, true, false, true)
 	if err != nil {
 		log.Printf("Unable to get next game: %v\n", err)
@@ -445,24 +1115,27 @@ func (mp *MessageProcessor) processLineup(captain string) error {
 	defense := ""
 	goalie := ""
 	captainAssigned := false
+	var mentions []groupme.Mention
 	for _, player := range players {
 		name := player.Name.String
+		displayName := mp.mention(name, &mentions)
 		if player.Name.String == captain {
 			captainAssigned = true
 			name = fmt.Sprintf("%s (C)", name)
+			displayName = fmt.Sprintf("%s (C)", displayName)
 		}
 		switch player.Post.String {
 		case database.FORWARD:
 			i = fwdIndex
-			forward += fmt.Sprintf("%s %d\n", name, player.Number.Int64)
+			forward += fmt.Sprintf("%s %d\n", displayName, player.Number.Int64)
 			fwdIndex++
 		case database.DEFENSE:
 			i = defIndex
-			defense += fmt.Sprintf("%s %d\n", name, player.Number.Int64)
+			defense += fmt.Sprintf("%s %d\n", displayName, player.Number.Int64)
 			defIndex++
 		case database.GOALIE:
 			i = golIndex
-			goalie += fmt.Sprintf("%s %d\n", name, player.Number.Int64)
+			goalie += fmt.Sprintf("%s %d\n", displayName, player.Number.Int64)
 			golIndex++
 		default:
 			log.Printf("Unknown post: %s\n", player.Post.String)
@@ -479,44 +1152,46 @@ func (mp *MessageProcessor) processLineup(captain string) error {
 		}
 	}
 
-	mp.messageService.SendMessage(
+	err = ms.SendMessageWithMentions(
 		fmt.Sprintf(
 			"%s game\nFORWARD:\n%s\nDEFENSE:\n%s\nGOALIE:\n%s",
 			lastEvent.Name,
 			forward,
 			defense,
-			goalie), "")
+			goalie), "", mentions)
+	if err != nil {
+		log.Printf("Unable to send lineup: %v\n", err)
+		return err
+	}
 
 	if len(notProcessed) > 0 {
-		mp.messageService.SendMessage(
+		reply(ms,
 			fmt.Sprintf(
 				"Players not processed: \n%s",
-				strings.Join(notProcessed, ", ")), "")
+				strings.Join(notProcessed, ", ")))
 	}
 
 	if len(unknownPosts) > 0 {
-		mp.messageService.SendMessage(
+		reply(ms,
 			fmt.Sprintf(
 				"Unknown posts: \n%s",
-				strings.Join(unknownPosts, ", ")), "")
+				strings.Join(unknownPosts, ", ")))
 	}
 
 	if !captainAssigned {
-		mp.messageService.SendMessage(
+		reply(ms,
 			fmt.Sprintf(
 				"Captain not assigned: %s",
-				captain), "")
+				captain))
 	}
 
-	mp.messageService.SendMessage(
+	return ms.SendMessage(
 		fmt.Sprintf(
 			"Lineup sheet URL: %s",
 			sheetOperator.GetReadOnlyURL()), "")
-
-	return nil
 }
 
-func (mp *MessageProcessor) createGames(sheetURL string) error {
+func (mp *MessageProcessor) createGames(ms *groupme.MessageService, sheetURL string) error {
 	googleSheetOperator, err := google.NewSheetOperator(context.Background(), sheetURL)
 	if err != nil {
 		log.Printf("Unable to create sheet operator: %v\n", err)
@@ -548,10 +1223,10 @@ func (mp *MessageProcessor) createGames(sheetURL string) error {
 			log.Printf("Unable to create event: %v\n", err)
 			return err
 		}
-		mp.messageService.SendMessage(
+		reply(ms,
 			fmt.Sprintf(
 				"Event created: %s",
-				eventURL), "")
+				eventURL))
 		rowIndex++
 		row, err = googleSheetOperator.Get(fmt.Sprintf("Sheet1!A%d:%s%d", rowIndex, google.ToColumnIndex((5)), rowIndex), google.VRO_FORMATTED_VALUE, false)
 	}
@@ -562,24 +1237,24 @@ func (mp *MessageProcessor) createGames(sheetURL string) error {
 	return nil
 }
 
-func (mp *MessageProcessor) ScheduleException(edate, etime string) error {
+func (mp *MessageProcessor) ScheduleException(ms *groupme.MessageService, edate, etime string) error {
 	_, err := time.Parse("2006-01-02", edate)
 	if err != nil {
 		log.Printf("Unable to parse date: %v\n", err)
-		mp.messageService.SendMessage(
+		reply(ms,
 			fmt.Sprintf(
 				"Unable to parse date: %s",
-				edate), "")
+				edate))
 		return err
 	}
 	if etime != "" {
 		_, err := time.Parse("15:04", etime)
 		if err != nil {
 			log.Printf("Unable to parse time: %v\n", err)
-			mp.messageService.SendMessage(
+			reply(ms,
 				fmt.Sprintf(
 					"Unable to parse time: %s",
-					etime), "")
+					etime))
 			return err
 		}
 	}
@@ -589,10 +1264,9 @@ func (mp *MessageProcessor) ScheduleException(edate, etime string) error {
 		log.Printf("Unable to store schedule exception: %v\n", err)
 		return err
 	}
-	mp.messageService.SendMessage(
+	return ms.SendMessage(
 		fmt.Sprintf(
 			"Exception stored: %s %s",
 			edate,
 			etime), "")
-	return nil
 }
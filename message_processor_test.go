@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	graphql "github.com/hasura/go-graphql-client"
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/tymuj"
+)
+
+func TestEventPaymentSameAs(t *testing.T) {
+	newPayment := func(eventID string, charged []string, base int) *eventPayment {
+		matched := attendance{categories: map[string]string{"Dvořák": database.CATEGORY_JUNIOR}}
+		for _, name := range charged {
+			matched.processed = append(matched.processed, name)
+			matched.charged = append(matched.charged, name)
+			matched.scores = append(matched.scores, 1)
+		}
+		return &eventPayment{
+			event:   tymuj.Event{Id: graphql.ID(eventID)},
+			message: "hokej 2.10.",
+			matched: matched,
+			quote:   &PriceQuote{Base: base, BaseRule: "default"},
+		}
+	}
+	preview := newPayment("1", []string{"Novák", "Svoboda"}, 250)
+	tests := []struct {
+		name    string
+		current *eventPayment
+		want    bool
+	}{
+		{"unchanged", newPayment("1", []string{"Novák", "Svoboda"}, 250), true},
+		{"other event", newPayment("2", []string{"Novák", "Svoboda"}, 250), false},
+		{"attendee added", newPayment("1", []string{"Novák", "Svoboda", "Dvořák"}, 250), false},
+		{"attendee removed", newPayment("1", []string{"Novák"}, 250), false},
+		{"price changed", newPayment("1", []string{"Novák", "Svoboda"}, 300), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.current.sameAs(preview); got != tt.want {
+				t.Errorf("sameAs() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentionedUserIDs(t *testing.T) {
+	callback := `{"id": "1", "text": "@Jan @Petr PAY", "attachments": [{"type": "image", "url": "https://i.groupme.com/1.png"}, {"type": "mentions", "user_ids": ["11", "12"], "loci": [[0, 4], [5, 5]]}]}`
+	var m GroupmeMessage
+	if err := json.Unmarshal([]byte(callback), &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.MentionedUserIDs(), []string{"11", "12"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MentionedUserIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vlcak/groupme_qr_bot/groupme"
+)
+
+const PENDING_OPERATION_TTL = 10 * time.Minute
+
+// pendingOperation is a previewed operation committed by CONFIRM
+type pendingOperation struct {
+	name      string
+	createdAt time.Time
+	commit    func(ms *groupme.MessageService) error
+}
+
+// setPending stores the operation of the user, the previous one is discarded
+func (mp *MessageProcessor) setPending(userID, name string, commit func(ms *groupme.MessageService) error) {
+	mp.pendingMutex.Lock()
+	defer mp.pendingMutex.Unlock()
+	if previous, ok := mp.pending[userID]; ok {
+		log.Printf("Discarding pending %s of %s\n", previous.name, userID)
+	}
+	mp.pending[userID] = &pendingOperation{
+		name:      name,
+		createdAt: time.Now(),
+		commit:    commit,
+	}
+}
+
+// takePending removes the operation of the user and returns it, nil when
+// there is none or it expired
+func (mp *MessageProcessor) takePending(userID string) *pendingOperation {
+	mp.pendingMutex.Lock()
+	defer mp.pendingMutex.Unlock()
+	// forget the expired operations of all users
+	for id, operation := range mp.pending {
+		if time.Since(operation.createdAt) > PENDING_OPERATION_TTL {
+			delete(mp.pending, id)
+		}
+	}
+	operation, ok := mp.pending[userID]
+	if !ok {
+		return nil
+	}
+	delete(mp.pending, userID)
+	return operation
+}
+
+func (mp *MessageProcessor) confirmPending(ms *groupme.MessageService, userID string) error {
+	operation := mp.takePending(userID)
+	if operation == nil {
+		return errors.New("nothing to confirm")
+	}
+	log.Printf("Confirmed %s of %s\n", operation.name, userID)
+	return operation.commit(ms)
+}
+
+func (mp *MessageProcessor) cancelPending(ms *groupme.MessageService, userID string) error {
+	operation := mp.takePending(userID)
+	if operation == nil {
+		return errors.New("nothing to cancel")
+	}
+	return ms.SendMessage(fmt.Sprintf("Discarded: %s", operation.name), "")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/groupme"
+	"github.com/vlcak/groupme_qr_bot/tymuj"
+	"github.com/vlcak/groupme_qr_bot/utils"
+)
+
+const (
+	// prices used when no base rule matches the event
+	DEFAULT_PRICE        = 250
+	DEFAULT_LARGE_PRICE  = 300
+	LARGE_EVENT_CAPACITY = 12
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func NewPricingEngine(db *database.Client) *PricingEngine {
+	return &PricingEngine{
+		db: db,
+	}
+}
+
+// PricingEngine computes price of the event per player category from the
+// pricing rules stored in DB, the most specific matching rule wins
+type PricingEngine struct {
+	db *database.Client
+}
+
+// PriceQuote holds price of one event for every player category
+type PriceQuote struct {
+	Base     int
+	BaseRule string
+	// prices and rules of the categories which differ from the base price
+	prices map[string]int
+	rules  map[string]string
+}
+
+// Price returns price for the player category, base price for the unknown
+// ones
+func (q *PriceQuote) Price(category string) int {
+	if price, ok := q.prices[category]; ok {
+		return price
+	}
+	return q.Base
+}
+
+// Rule describes the rule the price of the category comes from
+func (q *PriceQuote) Rule(category string) string {
+	if rule, ok := q.rules[category]; ok {
+		return rule
+	}
+	return q.BaseRule
+}
+
+// Quote prices the event, rinkCost is split among payers for split rules,
+// non-zero perUserAmount overrides the base price
+func (pe *PricingEngine) Quote(event tymuj.Event, rinkCost, perUserAmount, payers int) (*PriceQuote, error) {
+	rules, err := pe.db.GetPricingRules()
+	if err != nil {
+		log.Printf("Can't get pricing rules: %v", err)
+		return nil, err
+	}
+	quote := &PriceQuote{
+		// goalies are free unless a rule says otherwise
+		prices: map[string]int{database.GOALIE: 0},
+		rules:  map[string]string{database.GOALIE: "goalies are free"},
+	}
+
+	switch base := bestRule(rules, event, ""); {
+	case perUserAmount != 0:
+		quote.Base = perUserAmount
+		quote.BaseRule = "per user amount"
+	case base == nil:
+		quote.Base = DEFAULT_PRICE
+		if event.IsGame || event.Capacity > LARGE_EVENT_CAPACITY {
+			quote.Base = DEFAULT_LARGE_PRICE
+		}
+		quote.BaseRule = "default"
+	case base.Mode.String == database.PRICE_SPLIT:
+		if rinkCost == 0 {
+			rinkCost = int(base.Amount.Int64)
+		}
+		if payers <= 0 {
+			return nil, errors.New("no paying attendees to split the rink cost")
+		}
+		quote.Base = (rinkCost + payers - 1) / payers
+		quote.BaseRule = fmt.Sprintf("%s, %d/%d", ruleDescription(*base), rinkCost, payers)
+	case base.Mode.String == database.PRICE_FIXED:
+		quote.Base = int(base.Amount.Int64)
+		quote.BaseRule = ruleDescription(*base)
+	default:
+		return nil, fmt.Errorf("invalid base price mode: %s", ruleDescription(*base))
+	}
+
+	for _, category := range []string{database.GOALIE, database.CATEGORY_JUNIOR, database.CATEGORY_GUEST} {
+		rule := bestRule(rules, event, category)
+		if rule == nil {
+			continue
+		}
+		var price int
+		switch rule.Mode.String {
+		case database.PRICE_FIXED:
+			price = int(rule.Amount.Int64)
+		case database.PRICE_DISCOUNT:
+			price = max(quote.Base-int(rule.Amount.Int64), 0)
+		case database.PRICE_SURCHARGE:
+			price = quote.Base + int(rule.Amount.Int64)
+		default:
+			log.Printf("Invalid category price mode: %s", ruleDescription(*rule))
+			continue
+		}
+		quote.prices[category] = price
+		quote.rules[category] = ruleDescription(*rule)
+	}
+	return quote, nil
+}
+
+// bestRule returns the most specific rule of the category matching the event,
+// the newer one when they are equally specific
+func bestRule(rules []database.PricingRule, event tymuj.Event, category string) *database.PricingRule {
+	var best *database.PricingRule
+	bestSpecificity := -1
+	for i, rule := range rules {
+		if rule.Category.String != category || !ruleMatches(rule, event) {
+			continue
+		}
+		specificity := 0
+		if rule.EventType.String != "" {
+			specificity++
+		}
+		if rule.Location.String != "" {
+			specificity++
+		}
+		if rule.Weekday.Valid {
+			specificity++
+		}
+		if specificity >= bestSpecificity {
+			best = &rules[i]
+			bestSpecificity = specificity
+		}
+	}
+	return best
+}
+
+func ruleMatches(rule database.PricingRule, event tymuj.Event) bool {
+	if rule.EventType.String != "" && rule.EventType.String != eventType(event) {
+		return false
+	}
+	if rule.Location.String != "" && !strings.Contains(utils.Normalize(event.Location), utils.Normalize(rule.Location.String)) {
+		return false
+	}
+	if rule.Weekday.Valid && time.Weekday(rule.Weekday.Int64) != event.StartTime.Weekday() {
+		return false
+	}
+	return true
+}
+
+func eventType(event tymuj.Event) string {
+	if event.IsGame {
+		return database.EVENT_GAME
+	}
+	return database.EVENT_PRACTICE
+}
+
+// playerCategory returns pricing category of the player, goalies are
+// recognized by their post
+func playerCategory(player database.Player) string {
+	if player.Category.String != "" {
+		return player.Category.String
+	}
+	if player.Post.String == database.GOALIE {
+		return database.GOALIE
+	}
+	return ""
+}
+
+func ruleDescription(rule database.PricingRule) string {
+	var conditions []string
+	if rule.EventType.String != "" {
+		conditions = append(conditions, rule.EventType.String)
+	}
+	if rule.Location.String != "" {
+		conditions = append(conditions, rule.Location.String)
+	}
+	if rule.Weekday.Valid && rule.Weekday.Int64 >= 0 && int(rule.Weekday.Int64) < len(weekdays) {
+		conditions = append(conditions, weekdays[rule.Weekday.Int64])
+	}
+	if rule.Category.String != "" {
+		conditions = append(conditions, rule.Category.String)
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "any")
+	}
+	return fmt.Sprintf("#%d %s: %s %d", rule.Id.Int64, strings.Join(conditions, ", "), rule.Mode.String, rule.Amount.Int64)
+}
+
+// parseWeekday accepts weekday as its English abbreviation (mon) or number
+// (0 is Sunday)
+func parseWeekday(weekday string) (int, error) {
+	weekday = strings.ToLower(weekday)
+	for i, day := range weekdays {
+		if strings.HasPrefix(weekday, day) {
+			return i, nil
+		}
+	}
+	var day int
+	if _, err := fmt.Sscanf(weekday, "%d", &day); err != nil || day < 0 || day > 6 {
+		return 0, fmt.Errorf("invalid weekday: %s", weekday)
+	}
+	return day, nil
+}
+
+// previewPrice prints prices of the latest event PAY would charge
+func (mp *MessageProcessor) previewPrice(ms *groupme.MessageService, amount, perUserAmount int) error {
+	lastEvent, atendees, err := mp.lastEventAtendees()
+	if err != nil {
+		return err
+	}
+	players, err := mp.db.GetPlayers()
+	if err != nil {
+		log.Printf("Can't get players %v\n", err)
+		return err
+	}
+	matched := matchAttendees(players, atendees)
+	quote, err := mp.pricing.Quote(lastEvent, amount, perUserAmount, matched.payers())
+	if err != nil {
+		log.Printf("Can't price the event %v\n", err)
+		return err
+	}
+
+	total := quote.Price(database.CATEGORY_GUEST) * len(matched.hosts)
+	for _, name := range matched.charged {
+		total += quote.Price(matched.categories[name])
+	}
+	message := fmt.Sprintf("Price of %s %s (%s, %s):\n", eventType(lastEvent), lastEvent.StartTime.Format("2.1."), lastEvent.Location, weekdays[lastEvent.StartTime.Weekday()])
+	message += fmt.Sprintf("base: %d (%s)\n", quote.Base, quote.BaseRule)
+	for _, category := range []string{database.GOALIE, database.CATEGORY_JUNIOR, database.CATEGORY_GUEST} {
+		message += fmt.Sprintf("%s: %d (%s)\n", category, quote.Price(category), quote.Rule(category))
+	}
+	message += fmt.Sprintf("Players: %d, hosts: %d, payers: %d, total: %d", len(matched.charged), len(matched.hosts), matched.payers(), total)
+	return ms.SendMessage(message, "")
+}
+
+func (mp *MessageProcessor) listPricingRules(ms *groupme.MessageService) error {
+	rules, err := mp.db.GetPricingRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return ms.SendMessage(fmt.Sprintf("No pricing rules, default prices are %d and %d for games or events with capacity over %d", DEFAULT_PRICE, DEFAULT_LARGE_PRICE, LARGE_EVENT_CAPACITY), "")
+	}
+	message := "Pricing rules:\n"
+	for _, rule := range rules {
+		message += ruleDescription(rule) + "\n"
+	}
+	return ms.SendMessage(message, "")
+}
+
+func (mp *MessageProcessor) addPricingRule(ms *groupme.MessageService, mode string, amount int, eventType, location, weekday, category string) error {
+	rule := database.PricingRule{
+		Mode:     sql.NullString{String: strings.ToLower(mode), Valid: true},
+		Amount:   sql.NullInt64{Int64: int64(amount), Valid: true},
+		Location: sql.NullString{String: location, Valid: location != ""},
+	}
+	if amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+
+	switch eventType = strings.ToLower(eventType); eventType {
+	case "", database.EVENT_GAME, database.EVENT_PRACTICE:
+		rule.EventType = sql.NullString{String: eventType, Valid: eventType != ""}
+	default:
+		return fmt.Errorf("invalid event type: %s, use %s or %s", eventType, database.EVENT_GAME, database.EVENT_PRACTICE)
+	}
+
+	if weekday != "" {
+		day, err := parseWeekday(weekday)
+		if err != nil {
+			return err
+		}
+		rule.Weekday = sql.NullInt64{Int64: int64(day), Valid: true}
+	}
+
+	switch category = strings.ToLower(category); category {
+	case "":
+		if rule.Mode.String != database.PRICE_FIXED && rule.Mode.String != database.PRICE_SPLIT {
+			return fmt.Errorf("invalid mode: %s, use %s or %s", mode, database.PRICE_FIXED, database.PRICE_SPLIT)
+		}
+	case database.GOALIE, database.CATEGORY_JUNIOR, database.CATEGORY_GUEST:
+		rule.Category = sql.NullString{String: category, Valid: true}
+		if rule.Mode.String != database.PRICE_FIXED && rule.Mode.String != database.PRICE_DISCOUNT && rule.Mode.String != database.PRICE_SURCHARGE {
+			return fmt.Errorf("invalid mode: %s, use %s, %s or %s", mode, database.PRICE_FIXED, database.PRICE_DISCOUNT, database.PRICE_SURCHARGE)
+		}
+	default:
+		return fmt.Errorf("invalid category: %s, use %s, %s or %s", category, database.GOALIE, database.CATEGORY_JUNIOR, database.CATEGORY_GUEST)
+	}
+
+	id, err := mp.db.AddPricingRule(rule)
+	if err != nil {
+		return err
+	}
+	rule.Id = sql.NullInt64{Int64: id, Valid: true}
+	return ms.SendMessage(fmt.Sprintf("Pricing rule added: %s", ruleDescription(rule)), "")
+}
+
+func (mp *MessageProcessor) deletePricingRule(ms *groupme.MessageService, id int) error {
+	deleted, err := mp.db.DeletePricingRule(int64(id))
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("unknown pricing rule: %d", id)
+	}
+	return ms.SendMessage(fmt.Sprintf("Pricing rule %d deleted", id), "")
+}
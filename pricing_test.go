@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	database "github.com/vlcak/groupme_qr_bot/db"
+	"github.com/vlcak/groupme_qr_bot/tymuj"
+)
+
+func pricingRule(id int64, eventType, location string, weekday int, category, mode string, amount int64) database.PricingRule {
+	return database.PricingRule{
+		Id:        sql.NullInt64{Int64: id, Valid: true},
+		EventType: sql.NullString{String: eventType, Valid: eventType != ""},
+		Location:  sql.NullString{String: location, Valid: location != ""},
+		Weekday:   sql.NullInt64{Int64: int64(weekday), Valid: weekday >= 0},
+		Category:  sql.NullString{String: category, Valid: category != ""},
+		Mode:      sql.NullString{String: mode, Valid: true},
+		Amount:    sql.NullInt64{Int64: amount, Valid: true},
+	}
+}
+
+func TestBestRule(t *testing.T) {
+	// Wednesday practice
+	practice := tymuj.Event{Location: "Zimní stadion Nymburk", StartTime: time.Date(2024, 10, 2, 20, 0, 0, 0, time.Local)}
+	game := tymuj.Event{IsGame: true, Location: "Kolín", StartTime: time.Date(2024, 10, 5, 18, 0, 0, 0, time.Local)}
+
+	tests := []struct {
+		name     string
+		rules    []database.PricingRule
+		event    tymuj.Event
+		category string
+		wantID   int64
+	}{
+		{
+			name:   "no rules",
+			event:  practice,
+			wantID: 0,
+		},
+		{
+			name: "generic rule",
+			rules: []database.PricingRule{
+				pricingRule(1, "", "", -1, "", database.PRICE_FIXED, 250),
+			},
+			event:  practice,
+			wantID: 1,
+		},
+		{
+			name: "event type beats generic",
+			rules: []database.PricingRule{
+				pricingRule(1, database.EVENT_PRACTICE, "", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(2, "", "", -1, "", database.PRICE_FIXED, 300),
+			},
+			event:  practice,
+			wantID: 1,
+		},
+		{
+			name: "more conditions win",
+			rules: []database.PricingRule{
+				pricingRule(1, database.EVENT_PRACTICE, "nymburk", 3, "", database.PRICE_SPLIT, 6000),
+				pricingRule(2, database.EVENT_PRACTICE, "nymburk", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(3, database.EVENT_PRACTICE, "", -1, "", database.PRICE_FIXED, 300),
+			},
+			event:  practice,
+			wantID: 1,
+		},
+		{
+			name: "newer rule wins a tie",
+			rules: []database.PricingRule{
+				pricingRule(1, "", "nymburk", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(2, "", "", 3, "", database.PRICE_FIXED, 300),
+			},
+			event:  practice,
+			wantID: 2,
+		},
+		{
+			name: "non-matching rules are skipped",
+			rules: []database.PricingRule{
+				pricingRule(1, "", "", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(2, database.EVENT_GAME, "", -1, "", database.PRICE_FIXED, 400),
+				pricingRule(3, "", "kolín", -1, "", database.PRICE_FIXED, 350),
+				pricingRule(4, "", "", 6, "", database.PRICE_FIXED, 300),
+			},
+			event:  practice,
+			wantID: 1,
+		},
+		{
+			name: "game rule",
+			rules: []database.PricingRule{
+				pricingRule(1, "", "", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(2, database.EVENT_GAME, "KOLIN", 6, "", database.PRICE_FIXED, 400),
+			},
+			event:  game,
+			wantID: 2,
+		},
+		{
+			name: "category rules don't set base price",
+			rules: []database.PricingRule{
+				pricingRule(1, database.EVENT_PRACTICE, "", -1, database.CATEGORY_JUNIOR, database.PRICE_DISCOUNT, 100),
+			},
+			event:  practice,
+			wantID: 0,
+		},
+		{
+			name: "category rule",
+			rules: []database.PricingRule{
+				pricingRule(1, "", "", -1, "", database.PRICE_FIXED, 250),
+				pricingRule(2, "", "", -1, database.CATEGORY_JUNIOR, database.PRICE_DISCOUNT, 100),
+				pricingRule(3, database.EVENT_PRACTICE, "", -1, database.CATEGORY_JUNIOR, database.PRICE_FIXED, 50),
+				pricingRule(4, database.EVENT_PRACTICE, "", 3, database.CATEGORY_GUEST, database.PRICE_SURCHARGE, 50),
+			},
+			event:    practice,
+			category: database.CATEGORY_JUNIOR,
+			wantID:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := bestRule(tt.rules, tt.event, tt.category)
+			var id int64
+			if rule != nil {
+				id = rule.Id.Int64
+			}
+			if id != tt.wantID {
+				t.Errorf("bestRule() = %d, want %d", id, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var currencySuffixes = []string{",-", "kč", "kc", "czk"}
+
+// IsCurrency reports whether the word is a CZK currency marker, so it can be
+// skipped when it follows an amount
+func IsCurrency(word string) bool {
+	word = strings.ToLower(strings.TrimSpace(word))
+	for _, suffix := range currencySuffixes {
+		if word == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAmount parses amounts written the Czech way, e.g. "1 250,50 Kč",
+// "1.250,50", "250,-" or "99.90". The result is rounded to hundredths. A
+// single dot followed by three digits, e.g. "1.250", is rejected as it can be
+// both the thousands and the decimal separator.
+func ParseAmount(s string) (float64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	for _, suffix := range currencySuffixes {
+		value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+	}
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimSuffix(value, ",-")
+	if value == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	comma := strings.LastIndex(value, ",")
+	dot := strings.LastIndex(value, ".")
+	switch {
+	case comma != -1 && dot != -1:
+		// the later separator is the decimal one
+		if comma > dot {
+			value = strings.ReplaceAll(value, ".", "")
+			value = strings.Replace(value, ",", ".", 1)
+		} else {
+			value = strings.ReplaceAll(value, ",", "")
+		}
+	case comma != -1:
+		if strings.Count(value, ",") > 1 {
+			return 0, errors.New("too many decimal separators")
+		}
+		value = strings.Replace(value, ",", ".", 1)
+	case dot != -1:
+		// 1.250.000 has thousands separators, 12.50 a decimal one
+		if strings.Count(value, ".") > 1 {
+			value = strings.ReplaceAll(value, ".", "")
+		} else if len(value)-dot-1 == 3 {
+			return 0, errors.New("ambiguous amount, write it without the dot or with decimal comma")
+		}
+	}
+
+	if whole, decimals, found := strings.Cut(value, "."); found && (len(decimals) > 2 || whole == "") {
+		return 0, errors.New("invalid decimal part")
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.New("not a number")
+	}
+	if amount < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return 0, errors.New("amount must not be negative")
+	}
+	return math.Round(amount*100) / 100, nil
+}
+
+// FormatAmount formats the amount without trailing zeros, e.g. 250 or 312.5
+func FormatAmount(amount float64) string {
+	return strconv.FormatFloat(math.Round(amount*100)/100, 'f', -1, 64)
+}
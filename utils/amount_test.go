@@ -0,0 +1,71 @@
+package utils
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "250", want: 250},
+		{value: "1 250,50 Kč", want: 1250.5},
+		{value: "1.250,50", want: 1250.5},
+		{value: "1,250.50", want: 1250.5},
+		{value: "250,-", want: 250},
+		{value: "250 CZK", want: 250},
+		{value: "99.90", want: 99.9},
+		{value: "12.5", want: 12.5},
+		{value: "1.250.000", want: 1250000},
+		{value: "1.250", wantErr: true},
+		{value: "0,005", wantErr: true},
+		{value: "1,2,3", wantErr: true},
+		{value: ",5", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "Kč", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseAmount(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAmount(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{250, "250"},
+		{312.5, "312.5"},
+		{0.1 + 0.2, "0.3"},
+		{99.999, "100"},
+	}
+	for _, tt := range tests {
+		if got := FormatAmount(tt.amount); got != tt.want {
+			t.Errorf("FormatAmount(%v) = %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestIsCurrency(t *testing.T) {
+	for word, want := range map[string]bool{"Kč": true, "kc": true, "CZK": true, ",-": true, "EUR": false, "250": false} {
+		if got := IsCurrency(word); got != want {
+			t.Errorf("IsCurrency(%q) = %t, want %t", word, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	EPC_SERVICE_TAG     = "BCD"
+	EPC_VERSION         = "002"
+	EPC_CHARACTER_SET   = "1" // UTF-8
+	EPC_IDENTIFICATION  = "SCT"
+	EPC_CURRENCY        = "EUR"
+	EPC_LEVEL           = qrcode.Medium
+	EPC_NAME_MAX_LENGTH = 70
+	EPC_TEXT_MAX_LENGTH = 140
+	EPC_BIC_MAX_LENGTH  = 11
+	EPC_PURPOSE_LENGTH  = 4
+	EPC_PAYLOAD_MAX     = 331
+	EPC_AMOUNT_MIN      = 0.01
+	EPC_AMOUNT_MAX      = 999999999.99
+)
+
+// EPCPayment is a SEPA credit transfer as defined by EPC069-12, known as
+// "BCD" or GiroCode. The amount is always in EUR.
+type EPCPayment struct {
+	BIC     string
+	Name    string
+	IBAN    string
+	Amount  float64
+	Purpose string
+	Text    string
+}
+
+func (p *EPCPayment) Validate() error {
+	if p.Name == "" {
+		return errors.New("missing beneficiary name")
+	}
+	if p.IBAN == "" {
+		return errors.New("missing IBAN")
+	}
+	if len(p.BIC) > EPC_BIC_MAX_LENGTH {
+		return fmt.Errorf("invalid BIC: %s", p.BIC)
+	}
+	if p.Amount < EPC_AMOUNT_MIN || p.Amount > EPC_AMOUNT_MAX {
+		return fmt.Errorf("invalid amount: %.2f", p.Amount)
+	}
+	if p.Purpose != "" && len(p.Purpose) != EPC_PURPOSE_LENGTH {
+		return fmt.Errorf("invalid purpose: %s", p.Purpose)
+	}
+	return nil
+}
+
+// Encode returns the EPC payload, fields are separated by new lines
+func (p *EPCPayment) Encode() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	lines := []string{
+		EPC_SERVICE_TAG,
+		EPC_VERSION,
+		EPC_CHARACTER_SET,
+		EPC_IDENTIFICATION,
+		p.BIC,
+		truncate(oneLine(p.Name), EPC_NAME_MAX_LENGTH),
+		p.IBAN,
+		EPC_CURRENCY + strconv.FormatFloat(p.Amount, 'f', 2, 64),
+		p.Purpose,
+		// structured creditor reference isn't used, only the text
+		"",
+		truncate(oneLine(p.Text), EPC_TEXT_MAX_LENGTH),
+	}
+	content := strings.Join(lines, "\n")
+	if len(content) > EPC_PAYLOAD_MAX {
+		return "", fmt.Errorf("payload too long: %d bytes", len(content))
+	}
+	return content, nil
+}
+
+// Caption returns human readable lines printed under the QR code
+func (p *EPCPayment) Caption() []string {
+	caption := []string{
+		fmt.Sprintf("Amount: %.2f %s", p.Amount, EPC_CURRENCY),
+		fmt.Sprintf("Account: %s", p.IBAN),
+		fmt.Sprintf("Name: %s", p.Name),
+	}
+	if p.Text != "" {
+		caption = append(caption, fmt.Sprintf("Message: %s", p.Text))
+	}
+	return caption
+}
+
+func oneLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
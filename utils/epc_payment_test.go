@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEPCPaymentEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		payment EPCPayment
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "minimal",
+			payment: EPCPayment{Name: "B-Tým", IBAN: "CZ6508000000192000145399", Amount: 10.4},
+			want:    "BCD\n002\n1\nSCT\n\nB-Tým\nCZ6508000000192000145399\nEUR10.40\n\n\n",
+		},
+		{
+			name: "all fields",
+			payment: EPCPayment{
+				BIC:     "GIBACZPX",
+				Name:    "B-Tým",
+				IBAN:    "CZ6508000000192000145399",
+				Amount:  12.5,
+				Purpose: "GDDS",
+				Text:    "VS1012 hokej\nříjen",
+			},
+			want: "BCD\n002\n1\nSCT\nGIBACZPX\nB-Tým\nCZ6508000000192000145399\nEUR12.50\nGDDS\n\nVS1012 hokej říjen",
+		},
+		{
+			name:    "truncated name and text",
+			payment: EPCPayment{Name: strings.Repeat("n", 80), IBAN: "CZ6508000000192000145399", Amount: 1, Text: strings.Repeat("t", 150)},
+			want:    "BCD\n002\n1\nSCT\n\n" + strings.Repeat("n", EPC_NAME_MAX_LENGTH) + "\nCZ6508000000192000145399\nEUR1.00\n\n\n" + strings.Repeat("t", EPC_TEXT_MAX_LENGTH),
+		},
+		{name: "missing name", payment: EPCPayment{IBAN: "CZ6508000000192000145399", Amount: 1}, wantErr: true},
+		{name: "missing IBAN", payment: EPCPayment{Name: "B-Tým", Amount: 1}, wantErr: true},
+		{name: "zero amount", payment: EPCPayment{Name: "B-Tým", IBAN: "CZ6508000000192000145399"}, wantErr: true},
+		{name: "amount too big", payment: EPCPayment{Name: "B-Tým", IBAN: "CZ6508000000192000145399", Amount: 1000000000}, wantErr: true},
+		{name: "invalid BIC", payment: EPCPayment{BIC: "GIBACZPXXXXX", Name: "B-Tým", IBAN: "CZ6508000000192000145399", Amount: 1}, wantErr: true},
+		{name: "invalid purpose", payment: EPCPayment{Name: "B-Tým", IBAN: "CZ6508000000192000145399", Amount: 1, Purpose: "GOODS"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.payment.Encode()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Encode() = %q, want %q", got, tt.want)
+			}
+			if len(got) > EPC_PAYLOAD_MAX {
+				t.Errorf("payload has %d bytes, max is %d", len(got), EPC_PAYLOAD_MAX)
+			}
+		})
+	}
+}
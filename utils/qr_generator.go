@@ -1,59 +1,275 @@
 package utils
 
 import (
-	"image/color"
+	"errors"
+	"fmt"
 	"log"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	qrcode "github.com/skip2/go-qrcode"
+	"github.com/vlcak/groupme_qr_bot/bankaccount"
 )
 
 const (
-	LEVEL              = qrcode.Medium
-	SIZE               = 250
-	SPLITTER           = "*"
-	TYPE               = "SPD"
-	VERSION            = "1.0"
-	ACCOUNT            = "ACC:"
-	AMOUNT             = "AM:"
-	CURRENCY           = "CC:"
-	MESSAGE            = "MSG:"
-	MESSAGE_MAX_LENGTH = 60
+	LEVEL                = qrcode.Medium
+	SIZE                 = 250
+	SPLITTER             = "*"
+	TYPE                 = "SPD"
+	VERSION              = "1.0"
+	ACCOUNT              = "ACC:"
+	ALTERNATIVE_ACCOUNTS = "ALT-ACC:"
+	AMOUNT               = "AM:"
+	CURRENCY             = "CC:"
+	REFERENCE            = "RF:"
+	RECIPIENT_NAME       = "RN:"
+	DUE_DATE             = "DT:"
+	MESSAGE              = "MSG:"
+	VARIABLE_SYMBOL      = "X-VS:"
+	SPECIFIC_SYMBOL      = "X-SS:"
+	CONSTANT_SYMBOL      = "X-KS:"
+	RETRY_DAYS           = "X-PER:"
+
+	DEFAULT_CURRENCY          = "CZK"
+	DUE_DATE_FORMAT           = "20060102"
+	MESSAGE_MAX_LENGTH        = 60
+	RECIPIENT_NAME_MAX_LENGTH = 35
+	AMOUNT_MAX_LENGTH         = 10
+	SYMBOL_MAX_LENGTH         = 10
+	REFERENCE_MAX_LENGTH      = 16
+	RETRY_DAYS_MAX            = 30
+	ALTERNATIVE_ACCOUNTS_MAX  = 2
+)
+
+var (
+	digitsRegexp   = regexp.MustCompile(`^\d+$`)
+	currencyRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+	// SPD values must not contain the field splitter, percent sign is encoded
+	// as well so the encoded value can be decoded unambiguously
+	spdEscaper = strings.NewReplacer("%", "%25", "*", "%2A")
 )
 
-func NewQRPaymentGenerator() *QRPaymentGenerator {
+// SPDPayment holds fields of the Short Payment Descriptor 1.0, empty fields
+// are omitted from the encoded payment
+type SPDPayment struct {
+	// Account is IBAN, optionally followed by +BIC
+	Account             string
+	AlternativeAccounts []string
+	Amount              float64
+	// Currency defaults to CZK
+	Currency       string
+	Reference      string
+	RecipientName  string
+	DueDate        time.Time
+	Message        string
+	VariableSymbol string
+	SpecificSymbol string
+	ConstantSymbol string
+	// RetryDays tells the bank how many days to retry the payment when there
+	// are insufficient funds
+	RetryDays int
+}
+
+func (p *SPDPayment) Validate() error {
+	if p.Account == "" {
+		return errors.New("missing account")
+	}
+	if len(p.AlternativeAccounts) > ALTERNATIVE_ACCOUNTS_MAX {
+		return fmt.Errorf("at most %d alternative accounts allowed", ALTERNATIVE_ACCOUNTS_MAX)
+	}
+	if p.Amount < 0 || len(formatSPDAmount(p.Amount)) > AMOUNT_MAX_LENGTH {
+		return fmt.Errorf("invalid amount: %f", p.Amount)
+	}
+	if p.Currency != "" && !currencyRegexp.MatchString(p.Currency) {
+		return fmt.Errorf("invalid currency: %s", p.Currency)
+	}
+	for name, symbol := range map[string]string{"variable": p.VariableSymbol, "specific": p.SpecificSymbol, "constant": p.ConstantSymbol} {
+		if symbol != "" && (!digitsRegexp.MatchString(symbol) || len(symbol) > SYMBOL_MAX_LENGTH) {
+			return fmt.Errorf("invalid %s symbol: %s", name, symbol)
+		}
+	}
+	if p.Reference != "" && (!digitsRegexp.MatchString(p.Reference) || len(p.Reference) > REFERENCE_MAX_LENGTH) {
+		return fmt.Errorf("invalid reference: %s", p.Reference)
+	}
+	if p.RetryDays < 0 || p.RetryDays > RETRY_DAYS_MAX {
+		return fmt.Errorf("invalid retry days: %d", p.RetryDays)
+	}
+	return nil
+}
+
+// Encode returns the SPD string, e.g. SPD*1.0*ACC:CZ...*AM:250.00*CC:CZK*MSG:hokej
+func (p *SPDPayment) Encode() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	fields := []string{TYPE, VERSION, ACCOUNT + escapeSPD(p.Account)}
+	if len(p.AlternativeAccounts) > 0 {
+		accounts := make([]string, len(p.AlternativeAccounts))
+		for i, account := range p.AlternativeAccounts {
+			accounts[i] = escapeSPD(account)
+		}
+		fields = append(fields, ALTERNATIVE_ACCOUNTS+strings.Join(accounts, ","))
+	}
+	if p.Amount > 0 {
+		fields = append(fields, AMOUNT+formatSPDAmount(p.Amount))
+	}
+	currency := p.Currency
+	if currency == "" {
+		currency = DEFAULT_CURRENCY
+	}
+	fields = append(fields, CURRENCY+currency)
+	if p.Reference != "" {
+		fields = append(fields, REFERENCE+p.Reference)
+	}
+	if p.RecipientName != "" {
+		fields = append(fields, RECIPIENT_NAME+escapeSPD(truncate(p.RecipientName, RECIPIENT_NAME_MAX_LENGTH)))
+	}
+	if !p.DueDate.IsZero() {
+		fields = append(fields, DUE_DATE+p.DueDate.Format(DUE_DATE_FORMAT))
+	}
+	if p.Message != "" {
+		fields = append(fields, MESSAGE+escapeSPD(truncate(p.Message, MESSAGE_MAX_LENGTH)))
+	}
+	if p.VariableSymbol != "" {
+		fields = append(fields, VARIABLE_SYMBOL+p.VariableSymbol)
+	}
+	if p.SpecificSymbol != "" {
+		fields = append(fields, SPECIFIC_SYMBOL+p.SpecificSymbol)
+	}
+	if p.ConstantSymbol != "" {
+		fields = append(fields, CONSTANT_SYMBOL+p.ConstantSymbol)
+	}
+	if p.RetryDays > 0 {
+		fields = append(fields, RETRY_DAYS+strconv.Itoa(p.RetryDays))
+	}
+	return strings.Join(fields, SPLITTER), nil
+}
+
+// Caption returns human readable lines printed under the QR code
+func (p *SPDPayment) Caption() []string {
+	currency := p.Currency
+	if currency == "" {
+		currency = DEFAULT_CURRENCY
+	}
+	caption := []string{
+		fmt.Sprintf("Amount: %s %s", formatSPDAmount(p.Amount), currency),
+		fmt.Sprintf("Account: %s", p.Account),
+	}
+	if p.VariableSymbol != "" {
+		caption = append(caption, fmt.Sprintf("VS: %s", p.VariableSymbol))
+	}
+	if p.Message != "" {
+		caption = append(caption, fmt.Sprintf("Message: %s", p.Message))
+	}
+	return caption
+}
+
+// spdAccount converts account to IBAN keeping the optional +BIC suffix
+func spdAccount(account string) (string, error) {
+	number, bic, found := strings.Cut(account, "+")
+	iban, err := bankaccount.ToIBAN(number)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return iban + "+" + strings.TrimSpace(bic), nil
+	}
+	return iban, nil
+}
+
+func escapeSPD(value string) string {
+	return spdEscaper.Replace(value)
+}
+
+func formatSPDAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// truncate cuts the value to length runes so multi-byte characters aren't split
+func truncate(value string, length int) string {
+	if utf8.RuneCountInString(value) <= length {
+		return value
+	}
+	return string([]rune(value)[:length])
+}
+
+// NewQRPaymentGenerator creates generator rendering the codes with renderer,
+// plain PNG codes are rendered when it's nil
+func NewQRPaymentGenerator(renderer *QRRenderer) *QRPaymentGenerator {
+	if renderer == nil {
+		renderer = NewQRRenderer(SIZE, nil)
+	}
 	return &QRPaymentGenerator{
 		messageRegexp: regexp.MustCompile("[^A-Za-z0-9$%+-./: ]"),
+		renderer:      renderer,
 	}
 }
 
 type QRPaymentGenerator struct {
 	messageRegexp *regexp.Regexp
+	renderer      *QRRenderer
+}
+
+// SanitizeMessage transliterates the message, replaces characters banks don't
+// accept by spaces and truncates it to the SPD limit. It reports whether the
+// message had to be altered.
+func (qpg *QRPaymentGenerator) SanitizeMessage(message string) (string, bool) {
+	sanitized := qpg.messageRegexp.ReplaceAllLiteralString(Transliterate(message), " ")
+	sanitized = truncate(oneLine(sanitized), MESSAGE_MAX_LENGTH)
+	return sanitized, sanitized != message
 }
 
-func (qpg *QRPaymentGenerator) Generate(message, account, amount string) ([]byte, error) {
-	content := TYPE + SPLITTER + VERSION + SPLITTER
-	// add account
-	content = content + ACCOUNT + account + SPLITTER
-	// add amount
-	content = content + AMOUNT + amount + SPLITTER
-	// add message
-	escapedMessage := qpg.messageRegexp.ReplaceAllLiteralString(message, "")
-	if len(escapedMessage) > MESSAGE_MAX_LENGTH {
-		escapedMessage = escapedMessage[:MESSAGE_MAX_LENGTH]
+// GenerateSPD creates PNG QR code for the payment
+func (qpg *QRPaymentGenerator) GenerateSPD(payment SPDPayment) ([]byte, error) {
+	return qpg.RenderSPD(payment, QR_FORMAT_PNG)
+}
+
+// RenderSPD creates QR code for the payment in the format, domestic account
+// numbers are converted to IBAN as SPD requires
+func (qpg *QRPaymentGenerator) RenderSPD(payment SPDPayment, format string) ([]byte, error) {
+	payment.Message, _ = qpg.SanitizeMessage(payment.Message)
+	caption := payment.Caption()
+	var err error
+	if payment.Account, err = spdAccount(payment.Account); err != nil {
+		log.Printf("Invalid account: %v\n", err)
+		return nil, err
+	}
+	alternativeAccounts := make([]string, len(payment.AlternativeAccounts))
+	for i, account := range payment.AlternativeAccounts {
+		if alternativeAccounts[i], err = spdAccount(account); err != nil {
+			log.Printf("Invalid alternative account: %v\n", err)
+			return nil, err
+		}
+	}
+	payment.AlternativeAccounts = alternativeAccounts
+	content, err := payment.Encode()
+	if err != nil {
+		log.Printf("Invalid payment: %v\n", err)
+		return nil, err
 	}
-	content = content + MESSAGE + escapedMessage
 
 	log.Printf("QR content: %s\n", content)
-	var q *qrcode.QRCode
-
-	q, err := qrcode.New(content, LEVEL)
+	return qpg.renderer.Render(content, LEVEL, caption, format)
+}
 
+// GenerateEPC creates EPC (SEPA) QR code for the payment, domestic account
+// numbers are converted to IBAN
+func (qpg *QRPaymentGenerator) GenerateEPC(payment EPCPayment) ([]byte, error) {
+	caption := payment.Caption()
+	var err error
+	if payment.IBAN, err = bankaccount.ToIBAN(payment.IBAN); err != nil {
+		log.Printf("Invalid account: %v\n", err)
+		return nil, err
+	}
+	content, err := payment.Encode()
 	if err != nil {
+		log.Printf("Invalid payment: %v\n", err)
 		return nil, err
 	}
-	q.ForegroundColor = color.Black
-	q.BackgroundColor = color.White
 
-	return q.PNG(SIZE)
+	log.Printf("QR content: %q\n", content)
+	return qpg.renderer.Render(content, EPC_LEVEL, caption, QR_FORMAT_PNG)
 }
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSPDPaymentEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		payment SPDPayment
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "minimal",
+			payment: SPDPayment{Account: "CZ6508000000192000145399"},
+			want:    "SPD*1.0*ACC:CZ6508000000192000145399*CC:CZK",
+		},
+		{
+			name: "all fields",
+			payment: SPDPayment{
+				Account:             "CZ6508000000192000145399+GIBACZPX",
+				AlternativeAccounts: []string{"CZ7908000000002000145399"},
+				Amount:              1250.5,
+				Currency:            "EUR",
+				Reference:           "1234",
+				RecipientName:       "B-Tym",
+				DueDate:             time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+				Message:             "hokej",
+				VariableSymbol:      "1012",
+				SpecificSymbol:      "22",
+				ConstantSymbol:      "308",
+				RetryDays:           7,
+			},
+			want: "SPD*1.0*ACC:CZ6508000000192000145399+GIBACZPX*ALT-ACC:CZ7908000000002000145399*AM:1250.50*CC:EUR*RF:1234*RN:B-Tym*DT:20241001*MSG:hokej*X-VS:1012*X-SS:22*X-KS:308*X-PER:7",
+		},
+		{
+			name:    "escaping",
+			payment: SPDPayment{Account: "CZ6508000000192000145399", Amount: 100, Message: "50% * 2"},
+			want:    "SPD*1.0*ACC:CZ6508000000192000145399*AM:100.00*CC:CZK*MSG:50%25 %2A 2",
+		},
+		{
+			name:    "truncated message",
+			payment: SPDPayment{Account: "CZ6508000000192000145399", Message: strings.Repeat("ř", 70)},
+			want:    "SPD*1.0*ACC:CZ6508000000192000145399*CC:CZK*MSG:" + strings.Repeat("ř", MESSAGE_MAX_LENGTH),
+		},
+		{name: "missing account", payment: SPDPayment{Amount: 100}, wantErr: true},
+		{name: "negative amount", payment: SPDPayment{Account: "CZ6508000000192000145399", Amount: -1}, wantErr: true},
+		{name: "amount too long", payment: SPDPayment{Account: "CZ6508000000192000145399", Amount: 10000000}, wantErr: true},
+		{name: "invalid currency", payment: SPDPayment{Account: "CZ6508000000192000145399", Currency: "Kč"}, wantErr: true},
+		{name: "invalid variable symbol", payment: SPDPayment{Account: "CZ6508000000192000145399", VariableSymbol: "12a"}, wantErr: true},
+		{name: "variable symbol too long", payment: SPDPayment{Account: "CZ6508000000192000145399", VariableSymbol: "12345678901"}, wantErr: true},
+		{name: "invalid reference", payment: SPDPayment{Account: "CZ6508000000192000145399", Reference: "RF12"}, wantErr: true},
+		{name: "too many alternative accounts", payment: SPDPayment{Account: "CZ6508000000192000145399", AlternativeAccounts: []string{"A", "B", "C"}}, wantErr: true},
+		{name: "invalid retry days", payment: SPDPayment{Account: "CZ6508000000192000145399", RetryDays: 31}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.payment.Encode()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Encode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPDAccount(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+		wantErr bool
+	}{
+		{"19-2000145399/0800", "CZ6508000000192000145399", false},
+		{"19-2000145399/0800+GIBACZPX", "CZ6508000000192000145399+GIBACZPX", false},
+		{"CZ65 0800 0000 1920 0014 5399", "CZ6508000000192000145399", false},
+		{"2000145398/0800", "", true},
+	}
+	for _, tt := range tests {
+		got, err := spdAccount(tt.account)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("spdAccount(%q) expected error, got %q", tt.account, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("spdAccount(%q) = %q, %v, want %q", tt.account, got, err, tt.want)
+		}
+	}
+}
+
+func TestSanitizeMessage(t *testing.T) {
+	generator := NewQRPaymentGenerator(nil)
+	tests := []struct {
+		message     string
+		want        string
+		wantAltered bool
+	}{
+		{"hokej 1.10.", "hokej 1.10.", false},
+		{"Příliš žluťoučký kůň", "Prilis zlutoucky kun", true},
+		{"pivo * 2", "pivo 2", true},
+		{"hokej\nrijen", "hokej rijen", true},
+		{strings.Repeat("a", 70), strings.Repeat("a", MESSAGE_MAX_LENGTH), true},
+	}
+	for _, tt := range tests {
+		got, altered := generator.SanitizeMessage(tt.message)
+		if got != tt.want || altered != tt.wantAltered {
+			t.Errorf("SanitizeMessage(%q) = %q, %t, want %q, %t", tt.message, got, altered, tt.want, tt.wantAltered)
+		}
+	}
+}
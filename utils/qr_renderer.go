@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	QR_FORMAT_PNG = "png"
+	QR_FORMAT_SVG = "svg"
+	QR_FORMAT_PDF = "pdf"
+
+	// LOGO_RATIO is the part of the QR code width covered by the logo, it's
+	// small enough for High error correction to recover the hidden modules
+	LOGO_RATIO = 5
+	// CAPTION_MARGIN is the space around the caption in modules
+	CAPTION_MARGIN = 2
+
+	PDF_PAGE_WIDTH   = 595 // A4 in points
+	PDF_PAGE_HEIGHT  = 842
+	PDF_QR_SIZE      = 400
+	PDF_FONT_SIZE    = 14
+	PDF_LINE_SPACING = 20
+	PDF_LINE_LENGTH  = 55
+
+	// SVG_CHARS_PER_MODULE is roughly how many caption characters fit into
+	// the width of one module
+	SVG_CHARS_PER_MODULE = 1
+)
+
+var qrFormatContentTypes = map[string]string{
+	QR_FORMAT_PNG: "image/png",
+	QR_FORMAT_SVG: "image/svg+xml",
+	QR_FORMAT_PDF: "application/pdf",
+}
+
+// QRFormatContentType returns the MIME type of the format, empty string for
+// unknown formats
+func QRFormatContentType(format string) string {
+	return qrFormatContentTypes[format]
+}
+
+// LoadLogo reads PNG or JPEG logo placed in the centre of rendered QR codes
+func LoadLogo(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	logo, _, err := image.Decode(f)
+	return logo, err
+}
+
+// NewQRRenderer creates renderer of QR codes size pixels wide, logo is
+// optional
+func NewQRRenderer(size int, logo image.Image) *QRRenderer {
+	return &QRRenderer{
+		size: size,
+		logo: logo,
+	}
+}
+
+// QRRenderer draws QR codes as PNG, SVG or single page PDF with the logo in
+// the centre and the caption lines underneath
+type QRRenderer struct {
+	size int
+	logo image.Image
+}
+
+func (r *QRRenderer) Render(content string, level qrcode.RecoveryLevel, caption []string, format string) ([]byte, error) {
+	if r.logo != nil {
+		level = qrcode.High
+	}
+	q, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	// includes the quiet zone
+	modules := q.Bitmap()
+
+	switch format {
+	case QR_FORMAT_PNG, "":
+		return r.renderPNG(modules, caption)
+	case QR_FORMAT_SVG:
+		return r.renderSVG(modules, caption)
+	case QR_FORMAT_PDF:
+		return r.renderPDF(modules, caption)
+	}
+	return nil, fmt.Errorf("unknown QR format: %s", format)
+}
+
+func (r *QRRenderer) renderPNG(modules [][]bool, caption []string) ([]byte, error) {
+	scale := r.size / len(modules)
+	if scale < 1 {
+		scale = 1
+	}
+	width := len(modules) * scale
+	fontScale := scale / 3
+	if fontScale < 1 {
+		fontScale = 1
+	}
+	margin := CAPTION_MARGIN * scale
+	lineHeight := (GLYPH_HEIGHT + 2) * fontScale
+	lines := wrapLines(transliterateLines(caption), (width-2*margin)/((GLYPH_WIDTH+1)*fontScale))
+	height := width
+	if len(lines) > 0 {
+		height += len(lines)*lineHeight + margin
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	black := image.NewUniform(color.Black)
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				draw.Draw(img, image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale), black, image.Point{}, draw.Src)
+			}
+		}
+	}
+	if r.logo != nil {
+		logoRect := r.logoRect(width, scale)
+		draw.Draw(img, logoRect.Inset(-scale), image.NewUniform(color.White), image.Point{}, draw.Src)
+		drawScaled(img, logoRect, r.logo)
+	}
+	// the quiet zone is wide enough to start the caption right under the code
+	top := width - margin
+	for i, line := range lines {
+		drawText(img, margin, top+i*lineHeight, fontScale, line)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *QRRenderer) renderSVG(modules [][]bool, caption []string) ([]byte, error) {
+	size := len(modules)
+	lineHeight := 2
+	lines := wrapLines(caption, (size-2*CAPTION_MARGIN)*SVG_CHARS_PER_MODULE)
+	height := size + len(lines)*lineHeight
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		size, height, r.size, r.size*height/size)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, height)
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/>`)
+	if r.logo != nil {
+		logo, err := encodePNG(r.logo)
+		if err != nil {
+			return nil, err
+		}
+		rect := r.logoRect(size, 1)
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="#fff"/>`,
+			rect.Min.X-1, rect.Min.Y-1, rect.Dx()+2, rect.Dy()+2)
+		fmt.Fprintf(&buf, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`,
+			rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), base64.StdEncoding.EncodeToString(logo))
+	}
+	for i, line := range lines {
+		// baseline of the line, the caption starts in the quiet zone
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" font-family="sans-serif" font-size="1.4">`,
+			CAPTION_MARGIN, size-CAPTION_MARGIN+i*lineHeight+1)
+		if err := xml.EscapeText(&buf, []byte(line)); err != nil {
+			return nil, err
+		}
+		buf.WriteString(`</text>`)
+	}
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+// renderPDF creates A4 page with the QR code on top and the caption under
+// it, text uses the standard Helvetica font so the caption is transliterated
+func (r *QRRenderer) renderPDF(modules [][]bool, caption []string) ([]byte, error) {
+	moduleSize := float64(PDF_QR_SIZE) / float64(len(modules))
+	left := float64(PDF_PAGE_WIDTH-PDF_QR_SIZE) / 2
+	top := float64(PDF_PAGE_HEIGHT) - left
+
+	var content bytes.Buffer
+	content.WriteString("0 g\n")
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re\n", left+float64(x)*moduleSize, top-float64(y+1)*moduleSize, moduleSize, moduleSize)
+			}
+		}
+	}
+	content.WriteString("f\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	resources := "/Font << /F1 4 0 R >>"
+	if r.logo != nil {
+		rect := r.logoRect(len(modules), 1)
+		x := left + float64(rect.Min.X)*moduleSize
+		y := top - float64(rect.Max.Y)*moduleSize
+		size := float64(rect.Dx()) * moduleSize
+		fmt.Fprintf(&content, "1 g %.2f %.2f %.2f %.2f re f\n", x-moduleSize, y-moduleSize, size+2*moduleSize, size+2*moduleSize)
+		fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im1 Do Q\n", size, size, x, y)
+		logo, err := pdfImage(r.logo)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, logo)
+		resources += " /XObject << /Im1 5 0 R >>"
+	}
+	for i, line := range wrapLines(transliterateLines(caption), PDF_LINE_LENGTH) {
+		fmt.Fprintf(&content, "BT /F1 %d Tf 0 g %.2f %.2f Td (%s) Tj ET\n",
+			PDF_FONT_SIZE, left, top-PDF_QR_SIZE-float64((i+1)*PDF_LINE_SPACING), escapePDF(line))
+	}
+	objects = append(objects, pdfStream("", content.Bytes()))
+	objects[2] = fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << %s >> /Contents %d 0 R >>",
+		PDF_PAGE_WIDTH, PDF_PAGE_HEIGHT, resources, len(objects))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
+
+// logoRect returns square in the centre of QR code width units wide
+func (r *QRRenderer) logoRect(width, unit int) image.Rectangle {
+	size := width / LOGO_RATIO / unit * unit
+	offset := (width - size) / 2 / unit * unit
+	return image.Rect(offset, offset, offset+size, offset+size)
+}
+
+// wrapLines splits lines longer than width characters
+func wrapLines(lines []string, width int) []string {
+	if width < 1 {
+		return nil
+	}
+	var wrapped []string
+	for _, line := range lines {
+		runes := []rune(line)
+		for len(runes) > width {
+			wrapped = append(wrapped, string(runes[:width]))
+			runes = runes[width:]
+		}
+		wrapped = append(wrapped, string(runes))
+	}
+	return wrapped
+}
+
+func transliterateLines(lines []string) []string {
+	transliterated := make([]string, len(lines))
+	for i, line := range lines {
+		transliterated[i] = Transliterate(line)
+	}
+	return transliterated
+}
+
+func drawText(img *image.RGBA, x, y, scale int, text string) {
+	black := image.NewUniform(color.Black)
+	for _, r := range text {
+		for row, bits := range glyph(r) {
+			for col := 0; col < GLYPH_WIDTH; col++ {
+				if bits&(1<<(GLYPH_WIDTH-1-col)) != 0 {
+					px, py := x+col*scale, y+row*scale
+					draw.Draw(img, image.Rect(px, py, px+scale, py+scale), black, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += (GLYPH_WIDTH + 1) * scale
+	}
+}
+
+// drawScaled draws src into rect using nearest neighbour scaling
+func drawScaled(dst draw.Image, rect image.Rectangle, src image.Image) {
+	bounds := src.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		sy := bounds.Min.Y + (y-rect.Min.Y)*bounds.Dy()/rect.Dy()
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx := bounds.Min.X + (x-rect.Min.X)*bounds.Dx()/rect.Dx()
+			draw.Draw(dst, image.Rect(x, y, x+1, y+1), image.NewUniform(src.At(sx, sy)), image.Point{}, draw.Over)
+		}
+	}
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfImage encodes the image as RGB XObject, transparent pixels become white
+func pdfImage(img image.Image) (string, error) {
+	bounds := img.Bounds()
+	var raw bytes.Buffer
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			white := 0xffff - a
+			raw.Write([]byte{byte((r + white) >> 8), byte((g + white) >> 8), byte((b + white) >> 8)})
+		}
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	header := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		bounds.Dx(), bounds.Dy())
+	return pdfStream(header, compressed.Bytes()), nil
+}
+
+func pdfStream(header string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", header, len(data), data)
+}
+
+var pdfEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+
+// escapePDF escapes PDF string literal, characters outside ASCII are replaced
+// as the standard font encoding can't represent them reliably
+func escapePDF(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, value)
+	return pdfEscaper.Replace(value)
+}
@@ -8,11 +8,16 @@ import (
 )
 
 func Normalize(s string) string {
+	return strings.ToLower(Transliterate(s))
+}
+
+// Transliterate removes diacritics keeping the case, "Zápas" becomes "Zapas"
+func Transliterate(s string) string {
 	t := transform.Chain(norm.NFD, transform.RemoveFunc(func(r rune) bool {
 		return unicode.Is(unicode.Mn, r) // Mn: nonspacing marks
 	}), norm.NFC)
 	val, _, _ := transform.String(t, s)
-	return strings.ToLower(val)
+	return val
 }
 
 func NormalizeArray(a []string) []string {
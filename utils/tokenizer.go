@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const OPTION_PREFIX = "--"
+
+// Token is a single word of a message. Position is the 1-based character
+// column where the token starts in the original text.
+type Token struct {
+	Value    string
+	Position int
+	Quoted   bool
+}
+
+type ParseError struct {
+	Token    string
+	Position int
+	Reason   string
+}
+
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at position %d", e.Reason, e.Position)
+	}
+	return fmt.Sprintf("%s at position %d: %q", e.Reason, e.Position, e.Token)
+}
+
+func NewParseError(token Token, reason string) *ParseError {
+	return &ParseError{
+		Token:    token.Value,
+		Position: token.Position,
+		Reason:   reason,
+	}
+}
+
+type TokenizedMessage struct {
+	// Tokens holds positional words in order of appearance
+	Tokens []Token
+	// Options holds --name=value pairs, flags without value have empty Value
+	Options map[string]Token
+}
+
+// Tokenize splits message text on whitespace. Double quotes ("...", Czech
+// „...“ and English “...”) group several words into one token, --name=value and --flag
+// words are collected as options. Option names are case-insensitive.
+func Tokenize(text string) (*TokenizedMessage, error) {
+	message := &TokenizedMessage{
+		Options: map[string]Token{},
+	}
+	var current strings.Builder
+	var closingQuote rune
+	inToken := false
+	startsQuoted := false
+	quoted := false
+	start := 0
+	quoteStart := 0
+
+	finish := func() error {
+		if !inToken {
+			return nil
+		}
+		token := Token{
+			Value:    current.String(),
+			Position: start,
+			Quoted:   quoted,
+		}
+		current.Reset()
+		inToken = false
+		if startsQuoted || !strings.HasPrefix(token.Value, OPTION_PREFIX) {
+			message.Tokens = append(message.Tokens, token)
+			return nil
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(token.Value, OPTION_PREFIX), "=")
+		if name == "" {
+			return NewParseError(token, "missing option name")
+		}
+		name = strings.ToLower(name)
+		if _, ok := message.Options[name]; ok {
+			return NewParseError(token, "duplicate option")
+		}
+		message.Options[name] = Token{
+			Value:    value,
+			Position: token.Position,
+			Quoted:   token.Quoted,
+		}
+		return nil
+	}
+
+	position := 0
+	for _, r := range text {
+		position++
+		switch {
+		case closingQuote != 0:
+			if r == closingQuote {
+				closingQuote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '„' || r == '“':
+			if !inToken {
+				inToken = true
+				startsQuoted = true
+				quoted = false
+				start = position
+			}
+			quoted = true
+			quoteStart = position
+			switch r {
+			case '„':
+				closingQuote = '“'
+			case '“':
+				closingQuote = '”'
+			default:
+				closingQuote = '"'
+			}
+		case unicode.IsSpace(r):
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		default:
+			if !inToken {
+				inToken = true
+				startsQuoted = false
+				quoted = false
+				start = position
+			}
+			current.WriteRune(r)
+		}
+	}
+	if closingQuote != 0 {
+		return nil, &ParseError{
+			Token:    current.String(),
+			Position: quoteStart,
+			Reason:   "unterminated quote",
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
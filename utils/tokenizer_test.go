@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantTokens  []Token
+		wantOptions map[string]Token
+	}{
+		{
+			name:        "empty",
+			text:        "  ",
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "words and flag",
+			text:        "PAY  250 --dry-run",
+			wantTokens:  []Token{{Value: "PAY", Position: 1}, {Value: "250", Position: 6}},
+			wantOptions: map[string]Token{"dry-run": {Position: 10}},
+		},
+		{
+			name:        "double quotes",
+			text:        `QR 100 "hokej a pivo"`,
+			wantTokens:  []Token{{Value: "QR", Position: 1}, {Value: "100", Position: 4}, {Value: "hokej a pivo", Position: 8, Quoted: true}},
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "Czech quotes",
+			text:        "QR 100 „hokej říjen“",
+			wantTokens:  []Token{{Value: "QR", Position: 1}, {Value: "100", Position: 4}, {Value: "hokej říjen", Position: 8, Quoted: true}},
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "English quotes",
+			text:        "QR 100 “pivo a párek”",
+			wantTokens:  []Token{{Value: "QR", Position: 1}, {Value: "100", Position: 4}, {Value: "pivo a párek", Position: 8, Quoted: true}},
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "empty quotes",
+			text:        `LINK_PLAYER ""`,
+			wantTokens:  []Token{{Value: "LINK_PLAYER", Position: 1}, {Value: "", Position: 13, Quoted: true}},
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "quoted option value",
+			text:        `ADJUST -100 Jan --Note="pozdni platba"`,
+			wantTokens:  []Token{{Value: "ADJUST", Position: 1}, {Value: "-100", Position: 8}, {Value: "Jan", Position: 13}},
+			wantOptions: map[string]Token{"note": {Value: "pozdni platba", Position: 17, Quoted: true}},
+		},
+		{
+			name:        "quoted option prefix is a word",
+			text:        `QR 100 "--dry-run"`,
+			wantTokens:  []Token{{Value: "QR", Position: 1}, {Value: "100", Position: 4}, {Value: "--dry-run", Position: 8, Quoted: true}},
+			wantOptions: map[string]Token{},
+		},
+		{
+			name:        "positions in characters",
+			text:        "ř\n250",
+			wantTokens:  []Token{{Value: "ř", Position: 1}, {Value: "250", Position: 3}},
+			wantOptions: map[string]Token{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Tokenize(tt.text)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Tokens, tt.wantTokens) {
+				t.Errorf("tokens = %+v, want %+v", got.Tokens, tt.wantTokens)
+			}
+			if !reflect.DeepEqual(got.Options, tt.wantOptions) {
+				t.Errorf("options = %+v, want %+v", got.Options, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantReason   string
+		wantPosition int
+	}{
+		{"unterminated quote", `QR 100 "hokej`, "unterminated quote", 8},
+		{"unterminated Czech quote", "QR 100 „hokej\"", "unterminated quote", 8},
+		{"missing option name", "PAY 250 --=1", "missing option name", 9},
+		{"duplicate option", "PAY 250 --dry-run --DRY-RUN", "duplicate option", 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Tokenize(tt.text)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %v", err)
+			}
+			if parseErr.Reason != tt.wantReason || parseErr.Position != tt.wantPosition {
+				t.Errorf("error = %v, want %s at position %d", err, tt.wantReason, tt.wantPosition)
+			}
+		})
+	}
+}